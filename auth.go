@@ -0,0 +1,228 @@
+package exec
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// defaultKeys are the private keys tried for all servers when found, like OpenSSH does
+var defaultKeys = []string{
+	"~/.ssh/id_ed25519",
+	"~/.ssh/id_ecdsa",
+	"~/.ssh/id_rsa",
+	"~/.ssh/id_dsa",
+}
+
+// passphraseFunc returns the passphrase of an encrypted private key file
+type passphraseFunc func(file string) (string, error)
+
+// keyboardInteractiveFunc answers the questions of a keyboard-interactive authentication
+type keyboardInteractiveFunc func(user, instruction string, questions []string, echos []bool) (answers []string, err error)
+
+// Passphrase sets the func returning the passphrase of the encrypted private keys of all servers,
+// by default the passphrase is asked on the terminal
+func (e *Exec) Passphrase(f func(file string) (string, error)) {
+	e.passphrase = f
+}
+
+// authMethods returns the SSH authentication methods of s, tried in order,
+// and their descriptions used to explain an authentication failure
+func (e *Exec) authMethods(s *server) (methods []ssh.AuthMethod, tried []string, err error) {
+	var (
+		signers    []ssh.Signer
+		agentKeys  int
+		keyFiles   []string
+		certFiles  = s.certificates
+		fileSigner = make(map[string]ssh.Signer)
+		loaded     = make(map[string]bool)
+	)
+
+	// If there's a running SSH Agent, try to use its Private keys.
+	if sock, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK")); err == nil {
+		if agentSigners, err := agent.NewClient(sock).Signers(); err == nil && len(agentSigners) > 0 {
+			signers = append(signers, agentSigners...)
+			agentKeys = len(agentSigners)
+			tried = append(tried, fmt.Sprintf("agent (%d keys)", agentKeys))
+		}
+	}
+
+	// The server's keys must be loaded, the default ones are skipped if missing,
+	// or if encrypted while the agent already provides keys; a key is loaded once, whatever its path
+	for _, file := range s.sshClient.keys {
+		if loaded[expandHome(file)] {
+			continue
+		}
+		loaded[expandHome(file)] = true
+
+		signer, err := e.loadKey(s, expandHome(file))
+		if err != nil {
+			return nil, nil, fmt.Errorf("key %s: %v", file, err)
+		}
+		keyFiles = append(keyFiles, file)
+		fileSigner[file] = signer
+	}
+	for _, file := range defaultKeys {
+		if loaded[expandHome(file)] {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(expandHome(file))
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if _, ok := err.(*ssh.PassphraseMissingError); ok && agentKeys == 0 {
+			signer, err = e.decryptKey(s, expandHome(file), data)
+		}
+		if err != nil {
+			continue
+		}
+		keyFiles = append(keyFiles, file)
+		fileSigner[file] = signer
+	}
+
+	// OpenSSH certificates are looked up next to the keys
+	for _, file := range keyFiles {
+		if _, err := os.Stat(expandHome(file) + "-cert.pub"); err == nil && !contains(certFiles, file+"-cert.pub") {
+			certFiles = append(certFiles, file+"-cert.pub")
+		}
+	}
+
+	var keySigners []ssh.Signer
+	for _, file := range keyFiles {
+		keySigners = append(keySigners, fileSigner[file])
+	}
+	signers = append(signers, keySigners...)
+
+	for _, file := range certFiles {
+		certSigners, err := certSigners(file, signers)
+		if err != nil {
+			return nil, nil, fmt.Errorf("certificate %s: %v", file, err)
+		}
+		signers = append(certSigners, signers...)
+		tried = append(tried, "certificate "+file)
+	}
+
+	for _, file := range keyFiles {
+		tried = append(tried, "publickey "+file)
+	}
+
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	if s.password != nil {
+		methods = append(methods, ssh.Password(*s.password))
+		tried = append(tried, "password")
+	}
+
+	if s.keyboardInteractive != nil {
+		methods = append(methods, ssh.KeyboardInteractive(ssh.KeyboardInteractiveChallenge(s.keyboardInteractive)))
+		tried = append(tried, "keyboard-interactive")
+	} else if s.password != nil {
+		methods = append(methods, ssh.KeyboardInteractive(passwordChallenge(*s.password)))
+		tried = append(tried, "keyboard-interactive")
+	}
+
+	return methods, tried, nil
+}
+
+// loadKey reads and parses a private key file, decrypting it if needed
+func (e *Exec) loadKey(s *server, file string) (ssh.Signer, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		return e.decryptKey(s, file, data)
+	}
+	return signer, err
+}
+
+// decryptKey parses an encrypted private key with the passphrase of the server, of the exec, or asked on the terminal
+func (e *Exec) decryptKey(s *server, file string, data []byte) (ssh.Signer, error) {
+	f := s.passphrase
+	if f == nil {
+		f = e.passphrase
+	}
+	if f == nil {
+		f = e.promptPassphrase
+	}
+
+	// passphrases are asked one at a time, servers can be connected in parallel
+	e.promptMu.Lock()
+	passphrase, err := f(file)
+	e.promptMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+}
+
+// promptPassphrase asks the passphrase of a private key file on the terminal
+func (e *Exec) promptPassphrase(file string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return "", fmt.Errorf("no terminal to ask the passphrase of %s", file)
+	}
+
+	e.reporter.OnMessage("local", fmt.Sprintf("Enter passphrase for key %s:", file))
+	passphrase, err := terminal.ReadPassword(fd)
+	// the enter key typed after the passphrase isn't echoed
+	fmt.Println()
+
+	return string(passphrase), err
+}
+
+// certSigners returns the signers of the certificate file, for each signer matching the certified key
+func certSigners(file string, signers []ssh.Signer) (certSigners []ssh.Signer, err error) {
+	data, err := ioutil.ReadFile(expandHome(file))
+	if err != nil {
+		return nil, err
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, err
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("not a certificate")
+	}
+
+	for _, signer := range signers {
+		if bytes.Equal(signer.PublicKey().Marshal(), cert.Key.Marshal()) {
+			certSigner, err := ssh.NewCertSigner(cert, signer)
+			if err != nil {
+				return nil, err
+			}
+			certSigners = append(certSigners, certSigner)
+		}
+	}
+	if len(certSigners) == 0 {
+		return nil, fmt.Errorf("no private key found for the certified key")
+	}
+
+	return certSigners, nil
+}
+
+// passwordChallenge answers the hidden questions of a keyboard-interactive authentication with the password
+func passwordChallenge(password string) ssh.KeyboardInteractiveChallenge {
+	return func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i := range questions {
+			if !echos[i] {
+				answers[i] = password
+			}
+		}
+		return answers, nil
+	}
+}
@@ -0,0 +1,218 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/testdata"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestHome sets HOME to a temp dir without SSH agent, writing the given files in its .ssh dir
+func newTestHome(t *testing.T, files map[string][]byte) (string, func()) {
+	dir, err := ioutil.TempDir("", "exec-auth")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ".ssh"), 0700))
+	for name, data := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".ssh", name), data, 0600))
+	}
+
+	home, sock := os.Getenv("HOME"), os.Getenv("SSH_AUTH_SOCK")
+	_ = os.Setenv("HOME", dir)
+	_ = os.Unsetenv("SSH_AUTH_SOCK")
+
+	return dir, func() {
+		_ = os.Setenv("HOME", home)
+		_ = os.Setenv("SSH_AUTH_SOCK", sock)
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func encryptedTestKey(name string) (data []byte, passphrase string) {
+	for _, k := range testdata.PEMEncryptedKeys {
+		if k.Name == name {
+			return k.PEMBytes, k.EncryptionKey
+		}
+	}
+	return nil, ""
+}
+
+func TestExec_authMethods(t *testing.T) {
+	encrypted, passphrase := encryptedTestKey("ed25519-encrypted")
+
+	testCases := []struct {
+		name    string
+		files   map[string][]byte
+		setup   func(e *Exec, s *server)
+		tried   []string
+		methods int
+		err     string
+	}{
+		{
+			name:  "no keys",
+			tried: nil,
+		},
+		{
+			name:    "default keys",
+			files:   map[string][]byte{"id_ed25519": testdata.PEMBytes["ed25519"], "id_rsa": testdata.PEMBytes["rsa"]},
+			tried:   []string{"publickey ~/.ssh/id_ed25519", "publickey ~/.ssh/id_rsa"},
+			methods: 1,
+		},
+		{
+			name:    "server key",
+			files:   map[string][]byte{"deploy": testdata.PEMBytes["ecdsa"]},
+			setup:   func(e *Exec, s *server) { s.Key("~/.ssh/deploy") },
+			tried:   []string{"publickey ~/.ssh/deploy"},
+			methods: 1,
+		},
+		{
+			name:  "missing server key",
+			setup: func(e *Exec, s *server) { s.Key("~/.ssh/missing") },
+			err:   "key ~/.ssh/missing: open ",
+		},
+		{
+			name:  "encrypted key with the server's passphrase",
+			files: map[string][]byte{"id_ed25519": encrypted},
+			setup: func(e *Exec, s *server) {
+				e.Passphrase(func(file string) (string, error) { return "wrong", nil })
+				s.Passphrase(func(file string) (string, error) { return passphrase, nil })
+			},
+			tried:   []string{"publickey ~/.ssh/id_ed25519"},
+			methods: 1,
+		},
+		{
+			name:  "encrypted key with the exec's passphrase",
+			files: map[string][]byte{"deploy": encrypted},
+			setup: func(e *Exec, s *server) {
+				s.Key("~/.ssh/deploy")
+				e.Passphrase(func(file string) (string, error) { return passphrase, nil })
+			},
+			tried:   []string{"publickey ~/.ssh/deploy"},
+			methods: 1,
+		},
+		{
+			name:  "encrypted server key without passphrase",
+			files: map[string][]byte{"deploy": encrypted},
+			setup: func(e *Exec, s *server) {
+				s.Key("~/.ssh/deploy")
+				e.Passphrase(func(file string) (string, error) { return "", errors.New("no passphrase") })
+			},
+			err: "key ~/.ssh/deploy: no passphrase",
+		},
+		{
+			name:  "encrypted default key without passphrase is skipped",
+			files: map[string][]byte{"id_ed25519": encrypted, "id_rsa": testdata.PEMBytes["rsa"]},
+			setup: func(e *Exec, s *server) {
+				e.Passphrase(func(file string) (string, error) { return "", errors.New("no passphrase") })
+			},
+			tried:   []string{"publickey ~/.ssh/id_rsa"},
+			methods: 1,
+		},
+		{
+			name:    "certificate next to the key",
+			files:   map[string][]byte{"id_rsa": testdata.PEMBytes["rsa"], "id_rsa-cert.pub": testdata.SSHCertificates["rsa"]},
+			tried:   []string{"certificate ~/.ssh/id_rsa-cert.pub", "publickey ~/.ssh/id_rsa"},
+			methods: 1,
+		},
+		{
+			name:  "certificate without its key",
+			files: map[string][]byte{"id_ed25519": testdata.PEMBytes["ed25519"], "cert.pub": testdata.SSHCertificates["rsa"]},
+			setup: func(e *Exec, s *server) { s.Certificate("~/.ssh/cert.pub") },
+			err:   "certificate ~/.ssh/cert.pub: no private key found for the certified key",
+		},
+		{
+			name:    "password",
+			setup:   func(e *Exec, s *server) { s.Password("secret") },
+			tried:   []string{"password", "keyboard-interactive"},
+			methods: 2,
+		},
+		{
+			name: "keyboard interactive",
+			setup: func(e *Exec, s *server) {
+				s.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+					return nil, nil
+				})
+			},
+			tried:   []string{"keyboard-interactive"},
+			methods: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, cleanup := newTestHome(t, tc.files)
+			defer cleanup()
+
+			e := New()
+			s := e.Server("server", "root@domain.com")
+			if tc.setup != nil {
+				tc.setup(e, s)
+			}
+
+			methods, tried, err := e.authMethods(s)
+			if tc.err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.tried, tried)
+			require.Len(t, methods, tc.methods)
+		})
+	}
+}
+
+func TestExec_authMethodsLoadsKeysOnce(t *testing.T) {
+	encrypted, passphrase := encryptedTestKey("ed25519-encrypted")
+	_, cleanup := newTestHome(t, map[string][]byte{"id_ed25519": encrypted})
+	defer cleanup()
+
+	var prompts int
+	e := New()
+	e.Passphrase(func(file string) (string, error) {
+		prompts++
+		return passphrase, nil
+	})
+	s := e.Server("server", "root@domain.com").Key("~/.ssh/id_ed25519")
+
+	_, tried, err := e.authMethods(s)
+	require.NoError(t, err)
+	require.Equal(t, []string{"publickey ~/.ssh/id_ed25519"}, tried)
+	require.Equal(t, 1, prompts)
+}
+
+func TestCertSigners(t *testing.T) {
+	dir, cleanup := newTestHome(t, map[string][]byte{"id_rsa-cert.pub": testdata.SSHCertificates["rsa"]})
+	defer cleanup()
+
+	signer, err := ssh.ParsePrivateKey(testdata.PEMBytes["rsa"])
+	require.NoError(t, err)
+	other, err := ssh.ParsePrivateKey(testdata.PEMBytes["ed25519"])
+	require.NoError(t, err)
+
+	signers, err := certSigners(filepath.Join(dir, ".ssh", "id_rsa-cert.pub"), []ssh.Signer{other, signer})
+	require.NoError(t, err)
+	require.Len(t, signers, 1)
+	require.Equal(t, ssh.CertAlgoRSAv01, signers[0].PublicKey().Type())
+}
+
+func TestPasswordChallenge(t *testing.T) {
+	answers, err := passwordChallenge("secret")("root", "", []string{"Username:", "Password:"}, []bool{true, false})
+	require.NoError(t, err)
+	require.Equal(t, []string{"", "secret"}, answers)
+}
+
+func TestSshClient_ConnectWithAuthFailure(t *testing.T) {
+	c := &sshClient{
+		authTried:       []string{"publickey ~/.ssh/id_rsa", "password"},
+		hostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	err := c.ConnectWith(context.Background(), "root@domain.com", func(net, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+		return nil, errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey], no supported methods remain")
+	})
+	require.EqualError(t, err, `Connect("root@domain.com:22"): ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey], no supported methods remain (tried: publickey ~/.ssh/id_rsa, password)`)
+}
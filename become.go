@@ -0,0 +1,180 @@
+package exec
+
+import (
+	"bytes"
+	"github.com/satori/go.uuid"
+	"io"
+	"sync"
+)
+
+// passwordMask replaces the sudo password in the output of the commands
+const passwordMask = "********"
+
+// As runs the remote command as user with sudo, answering the sudo prompt with the SudoPassword of the server if set;
+// it overrides the Become of the task
+func As(user string) CommandOption {
+	return func(o *commandOptions) {
+		o.user = user
+	}
+}
+
+// Sudo runs the remote command as root with sudo, like As("root")
+func Sudo() CommandOption {
+	return As("root")
+}
+
+// become runs the commands as another user with sudo, answering its password prompt over the stdin of the command
+type become struct {
+	user     string
+	password *string
+	prompt   string
+	success  string
+	stdin    io.WriteCloser
+	mu       sync.Mutex
+	answered bool
+	ready    chan struct{}
+}
+
+// newBecome returns how to run a command as user, with the sudo password if any
+func newBecome(user string, password *string) *become {
+	key := uuid.NewV4().String()
+	return &become{
+		user:     user,
+		password: password,
+		prompt:   "[sudo via exec, key=" + key + "] password:",
+		success:  "exec-become-success-" + key,
+		ready:    make(chan struct{}),
+	}
+}
+
+// command wraps command with sudo; without password, sudo fails instead of prompting,
+// unless the command is interactive and the user can answer
+func (b *become) command(command string, interactive bool) string {
+	switch {
+	case interactive:
+		return "sudo -H -u " + shellQuote(b.user) + " -- /bin/sh -c " + shellQuote(command)
+	case b.password == nil:
+		return "sudo -H -n -u " + shellQuote(b.user) + " -- /bin/sh -c " + shellQuote(command)
+	default:
+		return "sudo -H -S -p " + shellQuote(b.prompt) + " -u " + shellQuote(b.user) + " -- /bin/sh -c " + shellQuote("echo "+b.success+" >&2; "+command)
+	}
+}
+
+// waitReady returns a channel closed once sudo ran the command, as the input of the command would be read as the password before;
+// it is already closed without become or password
+func (b *become) waitReady() <-chan struct{} {
+	if b == nil || b.password == nil {
+		ready := make(chan struct{})
+		close(ready)
+		return ready
+	}
+	return b.ready
+}
+
+// answer writes the password to the prompt of sudo; a second prompt means the password is wrong,
+// so the stdin is closed for sudo to fail
+func (b *become) answer() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.answered {
+		_ = b.stdin.Close()
+		return
+	}
+	b.answered = true
+	_, _ = io.WriteString(b.stdin, *b.password+"\n")
+}
+
+// succeeded records that sudo ran the command
+func (b *become) succeeded() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	select {
+	case <-b.ready:
+	default:
+		close(b.ready)
+	}
+}
+
+// output filters an output of the command, answering the sudo prompt, removing the prompt and the success marker,
+// and masking the password
+func (b *become) output(r io.Reader) io.Reader {
+	if b.password == nil {
+		return r
+	}
+	return &becomeOutput{r: r, become: b}
+}
+
+// becomeOutput is an output of a command run with become, filtered as it is read
+type becomeOutput struct {
+	r       io.Reader
+	become  *become
+	pending []byte
+	out     []byte
+	err     error
+}
+
+func (o *becomeOutput) Read(p []byte) (int, error) {
+	buf := make([]byte, 4096)
+	for len(o.out) == 0 {
+		if o.err != nil {
+			if len(o.pending) == 0 {
+				return 0, o.err
+			}
+			o.out, o.pending = o.pending, nil
+			break
+		}
+
+		n, err := o.r.Read(buf)
+		o.pending = append(o.pending, buf[:n]...)
+		o.err = err
+		o.filter()
+	}
+
+	n := copy(p, o.out)
+	o.out = o.out[n:]
+	return n, nil
+}
+
+// filter moves the pending bytes to out, handling the tokens found in them,
+// and keeping the end that could be the start of a token not read yet
+func (o *becomeOutput) filter() {
+	tokens := []string{o.become.prompt, o.become.success + "\n", *o.become.password}
+
+	for {
+		at, token := -1, ""
+		for _, t := range tokens {
+			if i := bytes.Index(o.pending, []byte(t)); i != -1 && (at == -1 || i < at) && t != "" {
+				at, token = i, t
+			}
+		}
+		if at == -1 {
+			break
+		}
+
+		o.out = append(o.out, o.pending[:at]...)
+		o.pending = o.pending[at+len(token):]
+
+		switch token {
+		case o.become.prompt:
+			o.become.answer()
+		case o.become.success + "\n":
+			o.become.succeeded()
+		default:
+			o.out = append(o.out, passwordMask...)
+		}
+	}
+
+	keep := 0
+	for _, t := range tokens {
+		for n := len(t) - 1; n > keep; n-- {
+			if bytes.HasSuffix(o.pending, []byte(t[:n])) {
+				keep = n
+				break
+			}
+		}
+	}
+	o.out = append(o.out, o.pending[:len(o.pending)-keep]...)
+	o.pending = o.pending[len(o.pending)-keep:]
+}
@@ -0,0 +1,128 @@
+package exec
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// testStdin records what is written to the stdin of a command
+type testStdin struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (s *testStdin) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestBecome_command(t *testing.T) {
+	password := "secret"
+	b := newBecome("www-data", &password)
+
+	testCases := []struct {
+		name        string
+		become      *become
+		interactive bool
+		expected    string
+	}{
+		{
+			name:     "without password",
+			become:   newBecome("www-data", nil),
+			expected: `sudo -H -n -u 'www-data' -- /bin/sh -c 'cd /var/www; echo '\''ok'\'''`,
+		},
+		{
+			name:        "interactive",
+			become:      newBecome("www-data", &password),
+			interactive: true,
+			expected:    `sudo -H -u 'www-data' -- /bin/sh -c 'cd /var/www; echo '\''ok'\'''`,
+		},
+		{
+			name:     "with password",
+			become:   b,
+			expected: `sudo -H -S -p '` + b.prompt + `' -u 'www-data' -- /bin/sh -c 'echo ` + b.success + ` >&2; cd /var/www; echo '\''ok'\'''`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			command := tc.become.command("cd /var/www; echo 'ok'", tc.interactive)
+			require.Equal(t, tc.expected, command)
+			require.NotContains(t, command, password)
+		})
+	}
+}
+
+func TestBecome_output(t *testing.T) {
+	password := "secret"
+	b := newBecome("root", &password)
+	stdin := &testStdin{}
+	b.stdin = stdin
+
+	// read byte by byte, so the prompt and marker are split across reads
+	stderr := b.output(iotest.OneByteReader(strings.NewReader(b.prompt + b.success + "\nwarning: secret leaked\nnot a [sudo prompt\n")))
+	stdout := b.output(strings.NewReader("out\n"))
+
+	data, err := ioutil.ReadAll(stderr)
+	require.NoError(t, err)
+	require.Equal(t, "warning: ******** leaked\nnot a [sudo prompt\n", string(data))
+	require.Equal(t, "secret\n", stdin.String())
+	require.False(t, stdin.closed)
+
+	select {
+	case <-b.waitReady():
+	default:
+		t.Fatal("become not ready after the success marker")
+	}
+
+	data, err = ioutil.ReadAll(stdout)
+	require.NoError(t, err)
+	require.Equal(t, "out\n", string(data))
+}
+
+func TestBecome_outputWrongPassword(t *testing.T) {
+	password := "wrong"
+	b := newBecome("root", &password)
+	stdin := &testStdin{}
+	b.stdin = stdin
+
+	data, err := ioutil.ReadAll(b.output(strings.NewReader(b.prompt + "Sorry, try again.\n" + b.prompt + "sudo: 1 incorrect password attempt\n")))
+	require.NoError(t, err)
+	require.Equal(t, "Sorry, try again.\nsudo: 1 incorrect password attempt\n", string(data))
+	require.Equal(t, "wrong\n", stdin.String())
+	require.True(t, stdin.closed)
+
+	select {
+	case <-b.waitReady():
+		t.Fatal("become ready without the success marker")
+	default:
+	}
+}
+
+func TestBecome_withoutPassword(t *testing.T) {
+	r := strings.NewReader("out")
+	b := newBecome("root", nil)
+	require.Equal(t, r, b.output(r))
+
+	var nilBecome *become
+	<-nilBecome.waitReady()
+	<-b.waitReady()
+}
+
+func TestCtx_become(t *testing.T) {
+	e := New()
+	s := e.Server("prod1", "deploy@prod1").SudoPassword("secret")
+	task := e.Task("task", func() {}).Become("www-data")
+
+	require.Nil(t, e.newCtx(nil, s).become(commandOptions{}))
+
+	b := e.newCtx(task, s).become(commandOptions{})
+	require.Equal(t, "www-data", b.user)
+	require.Equal(t, "secret", *b.password)
+
+	require.Equal(t, "root", e.newCtx(task, s).become(commandOptions{user: "root"}).user)
+}
@@ -0,0 +1,63 @@
+package exec
+
+import (
+	"regexp"
+	"strings"
+)
+
+// shellSafe matches the args that don't need to be quoted in a shell command
+var shellSafe = regexp.MustCompile(`^[\w@%+=:,./-]+$`)
+
+// cmd is a command built from its argv, each arg being passed as a single word,
+// without being split or expanded by a shell
+type cmd struct {
+	ctx  *Ctx
+	argv []string
+}
+
+// Cmd returns a command running name with args, their {{var}} being parsed;
+// Local executes it directly, without /bin/sh, and Remote shell-quotes its args
+func (c *Ctx) Cmd(name string, args ...string) *cmd {
+	argv := []string{c.Parse(name)}
+	for _, arg := range args {
+		argv = append(argv, c.Parse(arg))
+	}
+	return &cmd{ctx: c, argv: argv}
+}
+
+// String returns the command as a shell command line, with its args quoted if needed
+func (c *cmd) String() string {
+	words := make([]string, len(c.argv))
+	for i, arg := range c.argv {
+		words[i] = shellArg(arg)
+	}
+	return strings.Join(words, " ")
+}
+
+// Local executes the command locally without /bin/sh, with its CommandOptions, like Timeout or Retry
+func (c *cmd) Local(options ...CommandOption) Output {
+	o := applyOptions(options)
+	o.argv = c.argv
+	return c.ctx.localCommand(c.String(), o)
+}
+
+// Remote runs the command on the server of the invocation, with its CommandOptions, like Timeout or Retry
+func (c *cmd) Remote(options ...CommandOption) Output {
+	return c.ctx.remoteCommand(c.String(), applyOptions(options))
+}
+
+// applyOptions returns the command options set by options
+func applyOptions(options []CommandOption) (o commandOptions) {
+	for _, option := range options {
+		option(&o)
+	}
+	return o
+}
+
+// shellArg quotes s as a single shell word, unless it has no special chars
+func shellArg(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return shellQuote(s)
+}
@@ -0,0 +1,61 @@
+package exec
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCmd_String(t *testing.T) {
+	testCases := []struct {
+		name     string
+		argv     []string
+		expected string
+	}{
+		{name: "safe args", argv: []string{"git", "clone", "git@github.com:go-exec/exec.git", "/var/www"}, expected: "git clone git@github.com:go-exec/exec.git /var/www"},
+		{name: "empty arg", argv: []string{"echo", ""}, expected: "echo ''"},
+		{name: "spaces and quotes", argv: []string{"echo", "it's a test"}, expected: `echo 'it'\''s a test'`},
+		{name: "shell chars", argv: []string{"echo", "$HOME; rm -rf / `id` *"}, expected: "echo '$HOME; rm -rf / `id` *'"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, (&cmd{argv: tc.argv}).String())
+		})
+	}
+}
+
+func TestCmd_Local(t *testing.T) {
+	e := New()
+	e.Set("message", "it's $HOME; `id`")
+
+	c := e.newCtx(nil, nil)
+
+	o := c.Cmd("printf", "%s|%s", "{{message}}", "a  b").Local()
+	require.NoError(t, o.Err())
+	require.Equal(t, "it's $HOME; `id`|a  b", o.String())
+
+	// without /bin/sh, the env vars are passed but not expanded in args
+	o = c.Cmd("printenv", "GREETING").Local(Env(map[string]string{"GREETING": "hello"}))
+	require.Equal(t, "hello", o.String())
+
+	o = c.Cmd("/non/existent", "arg").Local()
+	require.Error(t, o.Err())
+}
+
+func TestCmd_dryRun(t *testing.T) {
+	e := New()
+	e.DryRun(true)
+	s := e.Server("prod1", "root@prod1")
+
+	var commands []string
+	e.DryRunOutput(func(server, command string) string {
+		commands = append(commands, server+": "+command)
+		return ""
+	})
+
+	c := e.newCtx(nil, s)
+	c.Cmd("git", "clone", "{{repository}}", "/var/www/my app").Remote()
+	c.Cmd("echo", "{{x}}").Local()
+
+	require.Equal(t, []string{"prod1: git clone '{{repository}}' '/var/www/my app'", "local: echo '{{x}}'"}, commands)
+}
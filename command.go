@@ -0,0 +1,97 @@
+package exec
+
+import (
+	"io"
+	"time"
+)
+
+// CommandOption configures a single Local or Remote command, passed among its args
+type CommandOption func(o *commandOptions)
+
+// commandOptions are the settings of a single command
+type commandOptions struct {
+	timeout      time.Duration
+	retry        *retryPolicy
+	input        io.Reader
+	interactive  bool
+	pty          bool
+	user         string
+	env          map[string]string
+	argv         []string
+	exitCodes    []int
+	allowFailure bool
+}
+
+// Timeout interrupts the command if it is still running after d
+func Timeout(d time.Duration) CommandOption {
+	return func(o *commandOptions) {
+		o.timeout = d
+	}
+}
+
+// Input pipes r to the stdin of the command; it is read once, so the retries of the command get what remains of it
+func Input(r io.Reader) CommandOption {
+	return func(o *commandOptions) {
+		o.input = r
+	}
+}
+
+// Interactive attaches the command to the local terminal, like for a mysql shell or a tail -f:
+// the keys are sent to the command, and its output is displayed as is without being captured;
+// a remote command gets a pty of the size of the terminal, resized with it
+func Interactive() CommandOption {
+	return func(o *commandOptions) {
+		o.interactive = true
+	}
+}
+
+// Pty requests a pty for the remote command, like for the programs refusing to run without a terminal;
+// its stderr is then merged into its stdout, and its lines end with \r\n
+func Pty() CommandOption {
+	return func(o *commandOptions) {
+		o.pty = true
+	}
+}
+
+// ExitCodes accepts the given non-zero exit codes of the command, like 1 for a grep not matching;
+// they are kept in its Output without failing the task
+func ExitCodes(codes ...int) CommandOption {
+	return func(o *commandOptions) {
+		o.exitCodes = append(o.exitCodes, codes...)
+	}
+}
+
+// AllowFailure accepts any non-zero exit code of the command, kept in its Output without failing the task
+func AllowFailure() CommandOption {
+	return func(o *commandOptions) {
+		o.allowFailure = true
+	}
+}
+
+// commandArgs separates the CommandOptions from the format args of a command
+func commandArgs(args []interface{}) (options commandOptions, formatArgs []interface{}) {
+	for _, arg := range args {
+		if option, ok := arg.(CommandOption); ok {
+			option(&options)
+		} else {
+			formatArgs = append(formatArgs, arg)
+		}
+	}
+	return options, formatArgs
+}
+
+// accepts checks if the non-zero exit code of a command is accepted by its options
+func (o commandOptions) accepts(code int) bool {
+	if code < 1 {
+		return false
+	}
+	if o.allowFailure {
+		return true
+	}
+	for _, c := range o.exitCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package exec
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestCommandArgs(t *testing.T) {
+	testCases := []struct {
+		name            string
+		args            []interface{}
+		expectedOptions commandOptions
+		expectedArgs    []interface{}
+	}{
+		{
+			name: "no args",
+		},
+		{
+			name:         "format args only",
+			args:         []interface{}{"a", 1},
+			expectedArgs: []interface{}{"a", 1},
+		},
+		{
+			name:            "options among format args",
+			args:            []interface{}{"a", Timeout(time.Second), 1},
+			expectedOptions: commandOptions{timeout: time.Second},
+			expectedArgs:    []interface{}{"a", 1},
+		},
+		{
+			name:            "pty",
+			args:            []interface{}{Pty()},
+			expectedOptions: commandOptions{pty: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			options, args := commandArgs(tc.args)
+			require.Equal(t, tc.expectedOptions, options)
+			require.Equal(t, tc.expectedArgs, args)
+		})
+	}
+}
@@ -12,6 +12,30 @@ var globalOpt = map[string]*Option{
 		Default:     false,
 		Description: "Display help",
 	},
+	"dry-run": &Option{
+		Name:        "dry-run",
+		Type:        Bool,
+		Default:     false,
+		Description: "Print the commands and transfers with their servers, without running them",
+	},
+	"log-format": &Option{
+		Name:        "log-format",
+		Type:        String,
+		Default:     "text",
+		Description: "Log format, text or json for a JSON lines event log",
+	},
+	"log-file": &Option{
+		Name:        "log-file",
+		Type:        String,
+		Default:     "",
+		Description: "File the json event log is written to, stderr by default",
+	},
+	"refresh-inventory": &Option{
+		Name:        "refresh-inventory",
+		Type:        Bool,
+		Default:     false,
+		Description: "Discover the servers of the inventory providers again, ignoring their cache",
+	},
 }
 
 // Run is a high level function which adds special behaviour to the Tasks,
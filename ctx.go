@@ -0,0 +1,822 @@
+package exec
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoServer is the error of a remote command run without server context within a running task,
+// like from a task running in parallel using the exec helpers instead of its Ctx
+var ErrNoServer = errors.New("no server context for the remote command, use the Ctx of the task func")
+
+// Ctx is the execution context of a single task invocation,
+// it holds the task being executed, the server it's executed on, the working dir and the env vars;
+// it can be used from the goroutines started by the task func, the commands on a server running one at a time
+type Ctx struct {
+	exec    *Exec
+	ctx     context.Context
+	task    *task
+	server  *server
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	dir     string
+	dirs    []string
+	env     map[string]string
+	err     error
+	aborted bool
+}
+
+// Context returns the context.Context of the invocation
+func (c *Ctx) Context() context.Context {
+	return c.ctx
+}
+
+// Server returns the server the invocation runs on, nil for local only invocations
+func (c *Ctx) Server() *server {
+	return c.server
+}
+
+// Task returns the task being executed
+func (c *Ctx) Task() *task {
+	return c.task
+}
+
+// Dir returns the working dir of the invocation, set by Cd, PushDir or Within
+func (c *Ctx) Dir() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dir
+}
+
+// Err returns the first error of a command executed within this context
+func (c *Ctx) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Setenv sets an env var for all the next Local and Remote commands of the invocation, its {{var}} being parsed
+func (c *Ctx) Setenv(name, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.env[name] = value
+}
+
+// Getenv returns an env var of the next commands, set with Setenv or by the exec, server or task
+func (c *Ctx) Getenv(name string) string {
+	env, _ := c.environment(commandOptions{})
+	return env[name]
+}
+
+// Cd sets the working dir for all the next Local and Remote commands of the invocation
+func (c *Ctx) Cd(path string) {
+	c.cd(c.Parse(path), false)
+}
+
+// cd sets the parsed working dir, saving the current one first if push
+func (c *Ctx) cd(dir string, push bool) {
+	c.mu.Lock()
+	if push {
+		c.dirs = append(c.dirs, c.dir)
+	}
+	c.dir = dir
+	c.mu.Unlock()
+
+	if c.server != nil {
+		c.exec.reporter.OnCommand(c.server.Name, "cd "+dir)
+	}
+}
+
+// PushDir saves the working dir and cds into dir for the next Local and Remote commands of the invocation,
+// a relative dir being resolved from the working dir
+func (c *Ctx) PushDir(dir string) {
+	dir = c.Parse(dir)
+	if current := c.Dir(); current != "" && !path.IsAbs(dir) {
+		dir = path.Join(current, dir)
+	}
+
+	c.cd(dir, true)
+}
+
+// PopDir restores the working dir saved by the last PushDir, it does nothing if none
+func (c *Ctx) PopDir() {
+	c.mu.Lock()
+	if len(c.dirs) == 0 {
+		c.mu.Unlock()
+		return
+	}
+	c.dir = c.dirs[len(c.dirs)-1]
+	c.dirs = c.dirs[:len(c.dirs)-1]
+	dir := c.dir
+	c.mu.Unlock()
+
+	if c.server != nil && dir != "" {
+		c.exec.reporter.OnCommand(c.server.Name, "cd "+dir)
+	}
+}
+
+// Within runs f with dir as the working dir like PushDir, restoring the previous one afterwards,
+// even if f panics
+func (c *Ctx) Within(dir string, f func()) {
+	c.PushDir(dir)
+	defer c.PopDir()
+
+	f()
+}
+
+// Get gets a Config value either set in the Server or directly in exec
+func (c *Ctx) Get(name string) *config {
+	if c.server != nil {
+		if cfg, ok := c.server.Configs[name]; ok {
+			return cfg
+		}
+	}
+	if cfg, ok := c.exec.Configs[name]; ok {
+		return cfg
+	}
+	return nil
+}
+
+// Has checks if a Config is available
+func (c *Ctx) Has(name string) bool {
+	return c.Get(name) != nil
+}
+
+// parseVar matches the {{var}} of a text, with an optional filter like {{var | quote}}
+var parseVar = regexp.MustCompile(`\{\{\s*([\w\.\/]+)\s*(?:\|\s*(\w+)\s*)?\}\}`)
+
+// parseFilters are the filters applied to the values of the {{var | filter}}
+var parseFilters = map[string]func(string) string{
+	"quote": shellQuote,
+}
+
+// Parse parses text with Render, reporting its error and failing the invocation with it;
+// the text is returned as is on error
+func (c *Ctx) Parse(text string) string {
+	parsed, err := c.Render(text)
+	if err != nil {
+		c.parseFailed(err)
+		return text
+	}
+	return parsed
+}
+
+// parseFailed reports the error of a parsed text and fails the invocation with it
+func (c *Ctx) parseFailed(err error) (o Output) {
+	o.err = err
+	c.exec.reporter.OnError("local", err)
+	c.fail(err)
+	return o
+}
+
+// parseVars parses the {{var}} with Get(var), {{var | quote}} shell-quoting the value
+func (c *Ctx) parseVars(text string) string {
+	if !parseVar.MatchString(text) {
+		return text
+	}
+	return parseVar.ReplaceAllStringFunc(text, func(str string) string {
+		match := parseVar.FindStringSubmatch(str)
+		name, filter := match[1], match[2]
+		if !c.Has(name) {
+			return str
+		}
+
+		value := c.Parse(c.Get(name).String())
+		if filter != "" {
+			f, ok := parseFilters[filter]
+			if !ok {
+				return str
+			}
+			value = f(value)
+		}
+		return value
+	})
+}
+
+// Println parses a text template, if founds a {{ var }}, it automatically runs the Get(var) on it
+func (c *Ctx) Println(text string) {
+	c.exec.reporter.OnMessage("", c.Parse(text))
+}
+
+// Local runs a local command and displays/returns the output for further usage,
+// args being the format args of the command and its CommandOptions, like Timeout or Retry
+func (c *Ctx) Local(command string, args ...interface{}) (o Output) {
+	options, args := commandArgs(args)
+	command, err := c.Render(fmt.Sprintf(command, args...))
+	if err != nil {
+		return c.parseFailed(err)
+	}
+	return c.localCommand(command, options)
+}
+
+// localCommand runs a parsed local command, unless the invocation was aborted
+func (c *Ctx) localCommand(command string, options commandOptions) Output {
+	if err := c.abortErr(); err != nil {
+		return Output{err: err}
+	}
+	if c.exec.dryRun {
+		return c.dryRun("local", "", command)
+	}
+
+	return c.run("local", "", command, options, c.local)
+}
+
+// LocalWithInput runs a local command like Local, with input as its stdin
+func (c *Ctx) LocalWithInput(command string, input io.Reader, args ...interface{}) Output {
+	return c.Local(command, append(args, Input(input))...)
+}
+
+// local executes a local command
+func (c *Ctx) local(ctx context.Context, command string, options commandOptions) (o Output) {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	if options.argv != nil {
+		cmd = exec.CommandContext(ctx, options.argv[0], options.argv[1:]...)
+	}
+	cmd.Dir = c.Dir()
+
+	env, err := c.environment(options)
+	if err != nil {
+		o.err = err
+		c.exec.reporter.OnError("local", o.err)
+		return o
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), environ(env)...)
+	}
+
+	if options.interactive {
+		return c.localInteractive(ctx, cmd, options)
+	}
+	cmd.Stdin = options.input
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		o.err = err
+		c.exec.reporter.OnError("local", o.err)
+		return o
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		o.err = err
+		c.exec.reporter.OnError("local", o.err)
+		return o
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		o.err = contextErr(ctx, err)
+		c.exec.reporter.OnError("local", o.err)
+		return o
+	}
+
+	stopWatching := closeOnDone(ctx, stdout, stderr)
+	o.stdout, o.stderr, o.err = c.read("local", stdout, stderr)
+	o.text = strings.TrimSpace(o.stdout)
+	stopWatching()
+
+	if err := c.exited(ctx, "local", cmd.Wait(), options); err != nil {
+		o.err = err
+	}
+
+	return o
+}
+
+// localInteractive executes a local command attached to the local terminal
+func (c *Ctx) localInteractive(ctx context.Context, cmd *exec.Cmd, options commandOptions) (o Output) {
+	c.exec.promptMu.Lock()
+	defer c.exec.promptMu.Unlock()
+
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	o.err = c.exited(ctx, "local", cmd.Run(), options)
+
+	return o
+}
+
+// Remote runs a command on the server of the invocation,
+// args being the format args of the command and its CommandOptions, like Timeout or Retry
+func (c *Ctx) Remote(command string, args ...interface{}) (o Output) {
+	options, args := commandArgs(args)
+	command, err := c.Render(fmt.Sprintf(command, args...))
+	if err != nil {
+		return c.parseFailed(err)
+	}
+	return c.remoteCommand(command, options)
+}
+
+// remoteCommand runs a parsed command on the server of the invocation, if the task is allowed to run on it
+// and the invocation wasn't aborted
+func (c *Ctx) remoteCommand(command string, options commandOptions) (o Output) {
+	if o.err = c.abortErr(); o.err != nil {
+		return o
+	}
+
+	run, onServers := c.exec.shouldIRun(c.task)
+
+	if !run {
+		c.exec.commandNotAllowedToRunPrint(onServers, command)
+		return o
+	}
+
+	if c.server != nil {
+		return c.remoteRun(command, options)
+	}
+
+	// a task running on servers called the exec helpers while running in parallel, without current invocation
+	if c.task != nil || c.exec.running() {
+		o.err = ErrNoServer
+		c.exec.reporter.OnError("local", o.err)
+		c.fail(o.err)
+	}
+
+	return o
+}
+
+// RemoteWithInput runs a command on the server of the invocation like Remote, with input as its stdin
+func (c *Ctx) RemoteWithInput(command string, input io.Reader, args ...interface{}) Output {
+	return c.Remote(command, append(args, Input(input))...)
+}
+
+// RemoteAs runs a command on the server of the invocation like Remote, as user with sudo
+func (c *Ctx) RemoteAs(user, command string, args ...interface{}) Output {
+	return c.Remote(command, append(args, As(user))...)
+}
+
+// remoteRun executes a command on the server of the invocation
+func (c *Ctx) remoteRun(command string, options commandOptions) (o Output) {
+	server := c.server
+
+	if c.exec.dryRun {
+		return c.dryRun(server.Name, server.Dsn, command)
+	}
+
+	return c.run(server.Name, server.Name, command, options, c.remote)
+}
+
+// remote executes a command on the server of the invocation, connecting to it if needed
+func (c *Ctx) remote(ctx context.Context, command string, options commandOptions) (o Output) {
+	server := c.server
+
+	if err := ctx.Err(); err != nil {
+		o.err = contextErr(ctx, err)
+		c.exec.reporter.OnError(server.Name, o.err)
+		return o
+	}
+
+	err := c.connect(ctx)
+	if err != nil {
+		o.err = err
+		c.exec.reporter.OnError("local", err)
+		return o
+	}
+
+	env, err := c.environment(options)
+	if err != nil {
+		o.err = err
+		c.exec.reporter.OnError(server.Name, err)
+		return o
+	}
+
+	command = c.remotePrefix(env) + command
+	become := c.become(options)
+
+	// the connection has a single session, the commands of concurrent goroutines run one at a time
+	server.sshClient.sessionMu.Lock()
+	defer server.sshClient.sessionMu.Unlock()
+
+	if options.interactive {
+		if become != nil {
+			command = become.command(command, true)
+		}
+		return c.remoteInteractive(ctx, command, options)
+	}
+
+	if become != nil {
+		command = become.command(command, false)
+	}
+
+	err = c.start(ctx, command, c.pty(options))
+	if err != nil {
+		o.err = err
+		c.exec.reporter.OnError(server.Name, err)
+		return o
+	}
+
+	stdout, stderr := server.sshClient.remoteStdout, server.sshClient.remoteStderr
+	if become != nil {
+		become.stdin = server.sshClient.remoteStdin
+		stdout, stderr = become.output(stdout), become.output(stderr)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	if options.input != nil {
+		ready := become.waitReady()
+		go func(stdin io.WriteCloser) {
+			select {
+			case <-ready:
+				_, _ = io.Copy(stdin, options.input)
+				_ = stdin.Close()
+			case <-done:
+			}
+		}(server.sshClient.remoteStdin)
+	}
+
+	stopWatching := watch(ctx, server)
+	o.stdout, o.stderr, o.err = c.read(server.Name, stdout, stderr)
+	o.text = strings.TrimSpace(o.stdout)
+	stopWatching()
+
+	if err := c.exited(ctx, server.Name, server.sshClient.Wait(), options); err != nil {
+		o.err = err
+	}
+
+	return o
+}
+
+// start starts a command on the connected server of the invocation, connecting again once
+// if the server dropped the connection since the last command, e.g. while rebooting
+func (c *Ctx) start(ctx context.Context, command string, pty *ptyRequest) error {
+	client := c.server.sshClient
+
+	err := client.RunWith(command, pty)
+	if err == nil || client.connected() {
+		return err
+	}
+
+	c.exec.reporter.OnMessage(c.server.Name, fmt.Sprintf("connection lost, connecting again: %v", err))
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+	return client.RunWith(command, pty)
+}
+
+// remoteInteractive executes a command on the connected server of the invocation, attached to the local terminal
+func (c *Ctx) remoteInteractive(ctx context.Context, command string, options commandOptions) (o Output) {
+	server := c.server
+
+	c.exec.promptMu.Lock()
+	defer c.exec.promptMu.Unlock()
+
+	pty, restore, err := attachTerminal()
+	if err != nil {
+		o.err = err
+		c.exec.reporter.OnError("local", err)
+		return o
+	}
+	defer restore()
+
+	err = c.start(ctx, command, pty)
+	if err != nil {
+		restore()
+		o.err = err
+		c.exec.reporter.OnError(server.Name, err)
+		return o
+	}
+
+	stopStdin, err := forwardStdin(server.sshClient.remoteStdin)
+	if err != nil {
+		_ = server.sshClient.WriteClose()
+		_ = server.sshClient.Wait()
+		restore()
+		o.err = err
+		c.exec.reporter.OnError("local", err)
+		return o
+	}
+
+	stopWatching := watch(ctx, server)
+	stopResizes := forwardResizes(server.sshClient)
+	o.err = attach(server.sshClient.remoteStdout, server.sshClient.remoteStderr)
+	stopResizes()
+	stopWatching()
+	stopStdin()
+
+	err = server.sshClient.Wait()
+	restore()
+	o.err = c.exited(ctx, server.Name, err, options)
+
+	return o
+}
+
+// become returns how to run a remote command as the user requested by its options or its task, nil if none
+func (c *Ctx) become(options commandOptions) *become {
+	user := options.user
+	if user == "" && c.task != nil {
+		user = c.task.become
+	}
+	if user == "" {
+		return nil
+	}
+	return newBecome(user, c.server.sudoPassword)
+}
+
+// pty returns the pty requested for a remote command by its options or its task, nil if none
+func (c *Ctx) pty(options commandOptions) *ptyRequest {
+	if options.pty || (c.task != nil && c.task.pty) {
+		return defaultPty
+	}
+	return nil
+}
+
+// run executes a command with f, attempting it again while it fails as set by its retries or the ones of its task,
+// and records its error; name is where the command runs, and server is empty for the local commands
+func (c *Ctx) run(name, server, command string, options commandOptions, f func(ctx context.Context, command string, options commandOptions) Output) (o Output) {
+	retry := c.retryPolicy(options)
+
+	for attempt := 1; ; attempt++ {
+		o = c.attempt(name, server, command, options, f)
+		if o.err == nil || !retry.retries(attempt) || c.ctx.Err() != nil {
+			break
+		}
+
+		delay := retry.delay(attempt)
+		c.exec.reporter.OnMessage(name, fmt.Sprintf("attempt %d/%d of `%s` failed, retrying in %s", attempt, retry.attempts, command, delay))
+		c.exec.emit(Event{Type: CommandRetried, Task: c.taskName(), Server: server, Command: command, Attempt: attempt, Delay: delay, Error: eventErr(o.err)})
+
+		select {
+		case <-time.After(delay):
+		case <-c.ctx.Done():
+			o.err = contextErr(c.ctx, o.err)
+		}
+		if c.ctx.Err() != nil {
+			break
+		}
+	}
+
+	c.fail(o.err)
+
+	return o
+}
+
+// attempt executes a command once with f, within its timeout
+func (c *Ctx) attempt(name, server, command string, options commandOptions, f func(ctx context.Context, command string, options commandOptions) Output) (o Output) {
+	c.exec.reporter.OnCommand(name, command)
+	c.exec.emit(Event{Type: CommandStarted, Task: c.taskName(), Server: server, Command: command})
+
+	defer c.finish(&o, server, command, options, time.Now())
+
+	ctx, cancel := c.commandContext(options)
+	defer cancel()
+
+	return f(ctx, command, options)
+}
+
+// retryPolicy returns the retries of a command, set by its options or else by its task
+func (c *Ctx) retryPolicy(options commandOptions) retryPolicy {
+	if options.retry != nil {
+		return *options.retry
+	}
+	if c.task != nil {
+		return c.task.retry
+	}
+	return retryPolicy{}
+}
+
+// read reads the stdout and stderr of a started command until both are closed,
+// reporting them line by line as they are read under the name of where the command runs
+func (c *Ctx) read(name string, stdout, stderr io.Reader) (outS, errS string, err error) {
+	var (
+		outB, errB bytes.Buffer
+		errC       = make(chan error, 1)
+	)
+	go func() {
+		errC <- c.stream(name, Stderr, stderr, &errB)
+	}()
+
+	err = c.stream(name, Stdout, stdout, &outB)
+
+	if sErr := <-errC; sErr != nil && err == nil {
+		err = sErr
+	}
+
+	return outB.String(), errB.String(), err
+}
+
+// stream copies r to buf, reporting each line, the last one being completed with a newline if missing
+func (c *Ctx) stream(name string, stream Stream, r io.Reader, buf *bytes.Buffer) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			buf.Write(line)
+			if line[len(line)-1] != '\n' {
+				line = append(line, '\n')
+			}
+			c.exec.reporter.OnOutputChunk(name, stream, line)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// dryRun prints the command with its server instead of running it, and returns its fake output
+func (c *Ctx) dryRun(name, dsn, command string) (o Output) {
+	if dsn != "" {
+		c.exec.reporter.OnMessage(name, fmt.Sprintf("dry-run on %s `%s`", dsn, command))
+	} else {
+		c.exec.reporter.OnMessage(name, fmt.Sprintf("dry-run `%s`", command))
+	}
+
+	if c.exec.dryRunOutput != nil {
+		o.stdout = c.exec.dryRunOutput(name, command)
+		o.text = strings.TrimSpace(o.stdout)
+	}
+
+	server := name
+	if dsn == "" {
+		server = ""
+	}
+	c.exec.emit(Event{Type: CommandFinished, Task: c.taskName(), Server: server, Command: command, DryRun: true, ExitCode: &o.exitCode, Stdout: o.stdout})
+
+	return o
+}
+
+// finish completes the Output of a command started at start and emits it,
+// the exit codes accepted by its options not being errors
+func (c *Ctx) finish(o *Output, server, command string, options commandOptions, start time.Time) {
+	o.duration = time.Since(start)
+	o.exitCode = exitCode(o.err)
+	if options.accepts(o.exitCode) {
+		o.err = nil
+	}
+
+	c.exec.emit(Event{
+		Type:     CommandFinished,
+		Task:     c.taskName(),
+		Server:   server,
+		Command:  command,
+		ExitCode: &o.exitCode,
+		Duration: o.duration,
+		Stdout:   o.stdout,
+		Stderr:   o.stderr,
+		Error:    eventErr(o.err),
+	})
+}
+
+// exited returns the error of a finished command, reported unless its exit code is accepted by its options
+func (c *Ctx) exited(ctx context.Context, name string, err error, options commandOptions) error {
+	if err == nil {
+		return nil
+	}
+
+	err = contextErr(ctx, err)
+	if !options.accepts(exitCode(err)) {
+		c.exec.reporter.OnError(name, err)
+	}
+	return err
+}
+
+// commandContext returns the context of a command, bounded by its timeout if any
+func (c *Ctx) commandContext(options commandOptions) (context.Context, context.CancelFunc) {
+	if options.timeout > 0 {
+		return context.WithTimeout(c.ctx, options.timeout)
+	}
+	return context.WithCancel(c.ctx)
+}
+
+// taskName returns the name of the task of the invocation, empty outside of tasks
+func (c *Ctx) taskName() string {
+	if c.task == nil {
+		return ""
+	}
+	return c.task.Name
+}
+
+// connect opens the SSH connection to the server of the invocation, if not already opened, unless ctx is done first
+func (c *Ctx) connect(ctx context.Context) error {
+	return c.exec.connect(ctx, c.server, nil)
+}
+
+// remotePrefix returns the `cd` and `export` commands preceding a remote command
+func (c *Ctx) remotePrefix(env map[string]string) (prefix string) {
+	if dir := c.Dir(); dir != "" {
+		// exit instead of chaining with &&, which would only guard the next export and not the command
+		prefix += "cd " + shellDir(dir) + " || exit 1; "
+	}
+	for _, name := range envNames(env) {
+		prefix += "export " + name + "=" + shellQuote(env[name]) + "; "
+	}
+	return prefix
+}
+
+// shellDir quotes a remote dir as a single shell word, leaving a leading ~ to be expanded to the home dir
+func shellDir(dir string) string {
+	if dir == "~" {
+		return dir
+	}
+	if strings.HasPrefix(dir, "~/") {
+		return "~/" + shellArg(dir[2:])
+	}
+	return shellArg(dir)
+}
+
+// fail records the first command error of the invocation, and aborts it if its error policy is StopOnError,
+// or if the run was interrupted or the task timed out: the commands running in other goroutines are interrupted
+// and the next ones skipped, the task func checking Err to return early
+func (c *Ctx) fail(err error) {
+	if err == nil {
+		return
+	}
+
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	abort := c.task != nil && c.cancel != nil && (c.task.policy() == StopOnError || c.ctx.Err() != nil)
+	c.aborted = c.aborted || abort
+	c.mu.Unlock()
+
+	if abort {
+		c.cancel()
+	}
+}
+
+// abortErr returns the error the invocation was aborted with, nil if it wasn't
+func (c *Ctx) abortErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.aborted {
+		return c.err
+	}
+	return nil
+}
+
+// newCtx returns a new Ctx for an invocation of task t on server s
+func (e *Exec) newCtx(t *task, s *server) *Ctx {
+	c := &Ctx{
+		exec:   e,
+		ctx:    e.runContext(),
+		task:   t,
+		server: s,
+		env:    make(map[string]string),
+	}
+	if t != nil {
+		c.dir = t.Dir
+	}
+	return c
+}
+
+// current returns the Ctx of the current invocation,
+// or a new empty one when called outside of a sequential task invocation
+func (e *Exec) current() *Ctx {
+	if c := e.context(); c != nil {
+		return c
+	}
+	return e.newCtx(nil, nil)
+}
+
+// running checks if a task invocation is running, sequentially or in parallel
+func (e *Exec) running() bool {
+	e.invocationMu.Lock()
+	defer e.invocationMu.Unlock()
+	return e.invocations > 0
+}
+
+// context returns the Ctx of the current invocation, nil if none
+func (e *Exec) context() *Ctx {
+	e.invocationMu.Lock()
+	defer e.invocationMu.Unlock()
+	return e.invocation
+}
+
+// enter makes c the current invocation, the one the exec helpers act on, and returns a func restoring the previous one;
+// a nil c leaves the exec helpers without invocation, as while a task runs in parallel
+func (e *Exec) enter(c *Ctx) (restore func()) {
+	e.invocationMu.Lock()
+	previous := e.invocation
+	e.invocation = c
+	e.invocationMu.Unlock()
+
+	return func() {
+		e.invocationMu.Lock()
+		e.invocation = previous
+		e.invocationMu.Unlock()
+	}
+}
+
+// invoking counts a task invocation as running until the returned func is called
+func (e *Exec) invoking() (done func()) {
+	e.invocationMu.Lock()
+	e.invocations++
+	e.invocationMu.Unlock()
+
+	return func() {
+		e.invocationMu.Lock()
+		e.invocations--
+		e.invocationMu.Unlock()
+	}
+}
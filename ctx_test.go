@@ -0,0 +1,508 @@
+package exec
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExec_enter(t *testing.T) {
+	e := New()
+
+	outer := &Ctx{exec: e, server: &server{Name: "outer"}}
+	inner := &Ctx{exec: e, server: &server{Name: "inner"}}
+
+	restoreOuter := e.enter(outer)
+	require.Equal(t, outer, e.context())
+
+	restoreInner := e.enter(inner)
+	require.Equal(t, inner, e.context())
+
+	// the current invocation isn't bound to a goroutine
+	done := make(chan *Ctx)
+	go func() {
+		done <- e.context()
+	}()
+	require.Equal(t, inner, <-done)
+
+	restoreNone := e.enter(nil)
+	require.Nil(t, e.context())
+	restoreNone()
+
+	restoreInner()
+	require.Equal(t, outer, e.context())
+
+	restoreOuter()
+	require.Nil(t, e.context())
+}
+
+func TestCtx_Get(t *testing.T) {
+	e := New()
+	e.Set("name", "exec")
+	e.Set("overridden", "exec")
+
+	s := e.Server("server", "root@domain.com").Set("overridden", "server")
+
+	c := e.newCtx(nil, s)
+
+	require.Equal(t, "exec", c.Get("name").String())
+	require.Equal(t, "server", c.Get("overridden").String())
+	require.Nil(t, c.Get("missing"))
+	require.False(t, c.Has("missing"))
+	require.Equal(t, "exec server {{missing}}", c.Parse("{{name}} {{overridden}} {{missing}}"))
+}
+
+func TestCtx_Parse(t *testing.T) {
+	e := New()
+	e.Set("name", "exec")
+	e.Set("message", "it's {{name}}; rm -rf /")
+
+	c := e.newCtx(nil, nil)
+
+	testCases := []struct {
+		text     string
+		expected string
+	}{
+		{text: "{{name}}", expected: "exec"},
+		{text: "{{ name }}", expected: "exec"},
+		{text: "echo {{message}}", expected: "echo it's exec; rm -rf /"},
+		{text: "echo {{message | quote}}", expected: `echo 'it'\''s exec; rm -rf /'`},
+		{text: "echo {{ message|quote }}", expected: `echo 'it'\''s exec; rm -rf /'`},
+		{text: "echo {{name | unknown}}", expected: "echo {{name | unknown}}"},
+		{text: "echo {{missing | quote}}", expected: "echo {{missing | quote}}"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.text, func(t *testing.T) {
+			require.Equal(t, tc.expected, c.Parse(tc.text))
+		})
+	}
+
+	require.Equal(t, "it's exec; rm -rf /", c.Local("printf '%%s' {{message | quote}}").String())
+}
+
+func TestCtx_Local(t *testing.T) {
+	e := New()
+	e.Set("dir", "/")
+
+	c := e.newCtx(nil, nil)
+	c.Cd("{{dir}}")
+	c.Setenv("GREETING", "hello {{dir}}")
+
+	require.Equal(t, "/", c.Dir())
+	require.Equal(t, "/ hello /", c.Local("echo `pwd` $GREETING").String())
+	require.NoError(t, c.Err())
+
+	c.Local("exit 1")
+	require.Error(t, c.Err())
+}
+
+func TestCtx_Within(t *testing.T) {
+	e := New()
+	e.Set("root", "/usr")
+
+	c := e.newCtx(nil, nil)
+	c.PopDir()
+	require.Equal(t, "", c.Dir())
+
+	c.Within("{{root}}", func() {
+		require.Equal(t, "/usr", c.Local("pwd").String())
+
+		c.Within("bin", func() {
+			require.Equal(t, "/usr/bin", c.Local("pwd").String())
+		})
+
+		c.PushDir("/tmp")
+		require.Equal(t, "/tmp", c.Dir())
+		c.PopDir()
+
+		require.Equal(t, "/usr", c.Local("pwd").String())
+	})
+	require.Equal(t, "", c.Dir())
+
+	c.Cd("/")
+	c.Within("/usr", func() {
+		defer func() {
+			require.NotNil(t, recover())
+		}()
+		panic("boom")
+	})
+	require.Equal(t, "/", c.Dir())
+}
+
+func TestCtx_LocalExitCodes(t *testing.T) {
+	e := New()
+	e.ErrorPolicy(StopOnError)
+
+	var outputs []Output
+	task := e.TaskCtx("task", func(c *Ctx) {
+		outputs = append(outputs, c.Local("echo no match; exit 1", ExitCodes(1)))
+		outputs = append(outputs, c.Local("exit 3", AllowFailure()))
+		outputs = append(outputs, c.Local("exit 2", ExitCodes(1)))
+		outputs = append(outputs, c.Local("echo unreachable"))
+	})
+
+	require.Error(t, task.run())
+	require.Len(t, outputs, 4)
+
+	require.NoError(t, outputs[0].Err())
+	require.Equal(t, 1, outputs[0].ExitCode())
+	require.Equal(t, "no match", outputs[0].String())
+
+	require.NoError(t, outputs[1].Err())
+	require.Equal(t, 3, outputs[1].ExitCode())
+
+	require.Equal(t, 2, outputs[2].ExitCode())
+
+	// the failed command aborted the task, the next one being skipped
+	require.Equal(t, outputs[2].Err(), outputs[3].Err())
+	require.Equal(t, "", outputs[3].String())
+
+	require.Equal(t, 2, exitCode(task.results[0].err))
+}
+
+func TestCtx_remotePrefix(t *testing.T) {
+	e := New()
+
+	c := e.newCtx(nil, nil)
+	require.Equal(t, "", c.remotePrefix(nil))
+
+	c.Cd("/var/www")
+	require.Equal(t, `cd /var/www || exit 1; export A='a b'; export B='it'\''s'; `, c.remotePrefix(map[string]string{"B": "it's", "A": "a b"}))
+
+	c.dir = "/var/www/my app"
+	require.Equal(t, `cd '/var/www/my app' || exit 1; `, c.remotePrefix(nil))
+
+	c.dir = "~/releases; rm -rf ~"
+	require.Equal(t, `cd ~/'releases; rm -rf ~' || exit 1; `, c.remotePrefix(nil))
+}
+
+func TestCtx_remotePrefixFailingCd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("runs the prefix with sh")
+	}
+
+	c := New().newCtx(nil, nil)
+	c.Cd(filepath.Join(os.TempDir(), "exec-missing-dir"))
+
+	for _, env := range []map[string]string{nil, {"A": "a", "B": "b"}} {
+		out, err := exec.Command("sh", "-c", c.remotePrefix(env)+"echo ran; echo ran again").Output()
+		require.Error(t, err)
+		require.Equal(t, "", string(out))
+	}
+}
+
+func TestCtx_environment(t *testing.T) {
+	e := New()
+	e.Set("env", "prod")
+	e.Env(map[string]string{"APP_ENV": "{{env}}", "DEBUG": "0", "LEVEL": "exec"})
+
+	s := e.Server("prod1", "root@prod1").Set("env", "production")
+	s.Env(map[string]string{"LEVEL": "server", "HOST": "prod1"})
+
+	tk := e.Task("task", func() {}).Env(map[string]string{"LEVEL": "task", "DEBUG": "1"})
+
+	testCases := []struct {
+		name     string
+		task     *task
+		server   *server
+		setenv   map[string]string
+		options  []CommandOption
+		expected map[string]string
+	}{
+		{
+			name:     "exec",
+			expected: map[string]string{"APP_ENV": "prod", "DEBUG": "0", "LEVEL": "exec"},
+		},
+		{
+			name:     "server",
+			server:   s,
+			expected: map[string]string{"APP_ENV": "production", "DEBUG": "0", "LEVEL": "server", "HOST": "prod1"},
+		},
+		{
+			name:     "task",
+			task:     tk,
+			server:   s,
+			expected: map[string]string{"APP_ENV": "production", "DEBUG": "1", "LEVEL": "task", "HOST": "prod1"},
+		},
+		{
+			name:     "setenv",
+			task:     tk,
+			server:   s,
+			setenv:   map[string]string{"LEVEL": "setenv {{env}}"},
+			expected: map[string]string{"APP_ENV": "production", "DEBUG": "1", "LEVEL": "setenv production", "HOST": "prod1"},
+		},
+		{
+			name:     "command",
+			task:     tk,
+			server:   s,
+			setenv:   map[string]string{"LEVEL": "setenv"},
+			options:  []CommandOption{Env(map[string]string{"LEVEL": "command"}), Env(map[string]string{"EXTRA": "1"})},
+			expected: map[string]string{"APP_ENV": "production", "DEBUG": "1", "LEVEL": "command", "HOST": "prod1", "EXTRA": "1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := e.newCtx(tc.task, tc.server)
+			for name, value := range tc.setenv {
+				c.Setenv(name, value)
+			}
+
+			var args []interface{}
+			for _, option := range tc.options {
+				args = append(args, option)
+			}
+			options, _ := commandArgs(args)
+
+			env, err := c.environment(options)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, env)
+		})
+	}
+}
+
+func TestCtx_LocalEnv(t *testing.T) {
+	e := New()
+	e.Env(map[string]string{"GREETING": "hello", "NAME": "exec"})
+
+	c := e.newCtx(nil, nil)
+	c.Cd("/tmp")
+	require.Equal(t, "hello world", c.Local("echo $GREETING $NAME", Env(map[string]string{"NAME": "world"})).String())
+	require.Equal(t, "exec", c.Getenv("NAME"))
+
+	o := c.Local("echo $GREETING", Env(map[string]string{"BAD NAME": "x"}))
+	require.EqualError(t, o.Err(), `invalid env var name "BAD NAME"`)
+}
+
+func TestExec_TaskWithCtx(t *testing.T) {
+	e := New()
+	e.Server("s1", "root@s1")
+	e.Server("s2", "root@s2")
+
+	var servers []string
+	e.TaskCtx("ctx", func(c *Ctx) {
+		require.Equal(t, "ctx", c.Task().Name)
+		require.Equal(t, c, e.context())
+		servers = append(servers, c.Server().Name)
+	}).OnServers(func() []string {
+		return []string{"s1", "s2"}
+	})
+
+	e.Tasks["ctx"].run()
+
+	require.ElementsMatch(t, []string{"s1", "s2"}, servers)
+}
+
+func TestExec_RemoteWithoutServer(t *testing.T) {
+	e := New()
+	e.DryRun(true)
+	e.Server("s1", "root@s1")
+
+	var inTask, inGoroutine, inParallel, outside Output
+	e.TaskCtx("task", func(c *Ctx) {
+		inTask = c.Remote("hostname")
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			inGoroutine = e.Remote("hostname")
+		}()
+		<-done
+	}).OnServers(func() []string {
+		return []string{"s1"}
+	})
+
+	require.NoError(t, e.Tasks["task"].run())
+	require.NoError(t, inTask.Err())
+	require.NoError(t, inGoroutine.Err())
+
+	e.TaskCtx("parallel", func(c *Ctx) {
+		inParallel = e.Remote("hostname")
+	}).OnServers(func() []string {
+		return []string{"s1"}
+	}).Parallel(0)
+	require.NoError(t, e.Tasks["parallel"].run())
+	require.Equal(t, ErrNoServer, inParallel.Err())
+
+	e.Task("local", func() {
+		outside = e.Remote("hostname")
+	})
+	require.NoError(t, e.Tasks["local"].run())
+	require.Equal(t, ErrNoServer, outside.Err())
+	require.Equal(t, ErrNoServer, e.Tasks["local"].results[0].err)
+
+	require.NoError(t, e.Remote("hostname").Err())
+}
+
+func TestExec_TaskLegacyFunc(t *testing.T) {
+	e := New()
+
+	var called bool
+	e.Task("legacy", func() {
+		called = true
+		require.NotNil(t, e.context())
+	})
+
+	require.NoError(t, e.Tasks["legacy"].run())
+	require.True(t, called)
+}
+
+func TestCtx_DryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exec-dry-run")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	e := New()
+	e.DryRun(true)
+	e.Set("file", filepath.Join(dir, "file"))
+	e.Server("prod1", "root@127.0.0.1:1")
+
+	local := e.newCtx(nil, nil)
+	o := local.Local("touch {{file}}")
+	require.NoError(t, o.Err())
+	require.Equal(t, "", o.String())
+	require.NoError(t, local.Err())
+	_, err = os.Stat(filepath.Join(dir, "file"))
+	require.True(t, os.IsNotExist(err))
+
+	// remote commands and transfers don't connect
+	remote := e.newCtx(nil, e.Servers["prod1"])
+	require.NoError(t, remote.Remote("rm -rf /tmp/{{file}}").Err())
+	require.NoError(t, remote.Upload(filepath.Join(dir, "missing"), "/tmp/missing"))
+	require.NoError(t, remote.Download("/tmp/missing", filepath.Join(dir, "missing")))
+	require.NoError(t, remote.Err())
+	require.False(t, e.Servers["prod1"].sshClient.connOpened)
+
+	e.DryRunOutput(func(server, command string) string {
+		return server + ": " + command
+	})
+	require.Equal(t, "prod1: hostname", remote.Remote("hostname").String())
+	require.Equal(t, "local: whoami", local.Local("whoami").String())
+}
+
+func TestCtx_LocalStreamsStderr(t *testing.T) {
+	var buf bytes.Buffer
+
+	e := New()
+	e.Reporter(NewPlainReporter(&buf, WithStreamNames()))
+
+	// stderr is reported as soon as it is written, not after stdout is closed
+	o := e.newCtx(nil, nil).Local("echo first >&2; sleep 0.2; echo second; echo third >&2")
+	require.NoError(t, o.Err())
+	require.Equal(t, "second\n", o.Stdout())
+	require.Equal(t, "first\nthird\n", o.Stderr())
+	require.True(t, strings.HasPrefix(buf.String(), "[local] > `echo first >&2; sleep 0.2; echo second; echo third >&2`\n[local] stderr first\n"))
+}
+
+func TestCtx_LocalTimeout(t *testing.T) {
+	c := New().newCtx(nil, nil)
+
+	start := time.Now()
+	o := c.Local("sleep %d", 5, Timeout(100*time.Millisecond))
+	require.Equal(t, ErrTimeout, o.Err())
+	require.Equal(t, -1, o.ExitCode())
+	require.True(t, time.Since(start) < 5*time.Second)
+
+	o = c.Local("echo %s", "done", Timeout(time.Second))
+	require.NoError(t, o.Err())
+	require.Equal(t, "done", o.String())
+}
+
+func TestCtx_LocalInterrupted(t *testing.T) {
+	e := New()
+	c := e.newCtx(nil, nil)
+	e.interrupt()
+
+	require.Equal(t, ErrInterrupted, c.Local("echo skipped").Err())
+
+	e.resume()
+	require.NoError(t, e.newCtx(nil, nil).Local("echo resumed").Err())
+}
+
+func TestCtx_LocalRetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exec-retry")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	sink := &testSink{}
+
+	e := New()
+	e.Reporter(NewPlainReporter(&buf))
+	e.AddEventSink(sink)
+	e.Set("attempts", filepath.Join(dir, "attempts"))
+	c := e.newCtx(nil, nil)
+
+	// fails until its third attempt
+	command := "echo >> {{attempts}}; test $(wc -l < {{attempts}}) -ge 3"
+
+	o := c.Local(command, Retry(3, ConstantBackoff(time.Millisecond)))
+	require.NoError(t, o.Err())
+	require.NoError(t, c.Err())
+	require.Equal(t, []EventType{CommandStarted, CommandFinished, CommandRetried, CommandStarted, CommandFinished, CommandRetried, CommandStarted, CommandFinished}, sink.types())
+	require.Equal(t, 1, sink.events[2].Attempt)
+	require.Equal(t, time.Millisecond, sink.events[2].Delay)
+	require.Equal(t, "exit status 1", sink.events[2].Error)
+	require.Equal(t, 2, sink.events[5].Attempt)
+	require.Contains(t, buf.String(), "attempt 2/3 of `echo >> "+filepath.Join(dir, "attempts"))
+	require.Contains(t, buf.String(), "failed, retrying in 1ms\n")
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "attempts")))
+	o = c.Local(command, Retry(2, nil))
+	require.Error(t, o.Err())
+	require.Error(t, c.Err())
+}
+
+func TestCtx_LocalWithInput(t *testing.T) {
+	c := New().newCtx(nil, nil)
+
+	o := c.LocalWithInput("cat; echo %s", strings.NewReader("a\nb\n"), "c")
+	require.NoError(t, o.Err())
+	require.Equal(t, "a\nb\nc", o.String())
+
+	o = c.Local("wc -l", Input(strings.NewReader("1\n2\n3\n")))
+	require.Equal(t, "3", strings.TrimSpace(o.String()))
+}
+
+func TestCtx_LocalInteractive(t *testing.T) {
+	c := New().newCtx(nil, nil)
+
+	// the output goes to the terminal without being captured
+	o := c.Local("echo interactive", Interactive())
+	require.NoError(t, o.Err())
+	require.Equal(t, "", o.String())
+
+	require.Equal(t, 3, c.Local("exit 3", Interactive()).ExitCode())
+}
+
+func TestCtx_pty(t *testing.T) {
+	e := New()
+	withoutPty := e.Task("without", func() {})
+	withPty := e.Task("with", func() {}).Pty()
+
+	testCases := []struct {
+		name     string
+		task     *task
+		options  commandOptions
+		expected *ptyRequest
+	}{
+		{name: "no pty by default", task: withoutPty},
+		{name: "requested by the command", task: withoutPty, options: commandOptions{pty: true}, expected: defaultPty},
+		{name: "requested by the task", task: withPty, expected: defaultPty},
+		{name: "outside of tasks", options: commandOptions{pty: true}, expected: defaultPty},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, e.newCtx(tc.task, nil).pty(tc.options))
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package exec
+
+import (
+	"github.com/pkg/errors"
+	"regexp"
+	"sort"
+)
+
+// envName matches the names of the env vars that can be exported by a shell
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Env sets env vars for the command only, overriding the ones of the exec, server, task and invocation
+func Env(env map[string]string) CommandOption {
+	return func(o *commandOptions) {
+		o.env = mergeEnv(o.env, env)
+	}
+}
+
+// environment returns the env vars of a command, layered like the configs: the exec's ones,
+// overridden by the server's, the task's, the ones set with Setenv and the command's Env option;
+// the {{var}} in values are parsed
+func (c *Ctx) environment(options commandOptions) (map[string]string, error) {
+	env := make(map[string]string)
+	layers := []map[string]string{c.exec.env}
+	if c.server != nil {
+		layers = append(layers, c.server.env)
+	}
+	if c.task != nil {
+		layers = append(layers, c.task.env)
+	}
+	c.mu.Lock()
+	own := mergeEnv(nil, c.env)
+	c.mu.Unlock()
+	layers = append(layers, own, options.env)
+
+	for _, layer := range layers {
+		for name, value := range layer {
+			if !envName.MatchString(name) {
+				return nil, errors.Errorf("invalid env var name %q", name)
+			}
+			env[name] = c.Parse(value)
+		}
+	}
+
+	return env, nil
+}
+
+// mergeEnv sets the env vars of src in dst, allocating it if needed
+func mergeEnv(dst, src map[string]string) map[string]string {
+	if dst == nil {
+		dst = make(map[string]string, len(src))
+	}
+	for name, value := range src {
+		dst[name] = value
+	}
+	return dst
+}
+
+// environ returns env vars as name=value pairs, sorted by name
+func environ(env map[string]string) (pairs []string) {
+	for _, name := range envNames(env) {
+		pairs = append(pairs, name+"="+env[name])
+	}
+	return pairs
+}
+
+// envNames returns the sorted names of env vars
+func envNames(env map[string]string) (names []string) {
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,124 @@
+package exec
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// EventType is the kind of an Event
+type EventType string
+
+const (
+	// TaskStarted is emitted when a task starts, once per server
+	TaskStarted EventType = "task.started"
+	// TaskFinished is emitted when a task finishes, once per server
+	TaskFinished EventType = "task.finished"
+	// CommandStarted is emitted when a local or remote command starts
+	CommandStarted EventType = "command.started"
+	// CommandFinished is emitted when a local or remote command finishes
+	CommandFinished EventType = "command.finished"
+	// TransferStarted is emitted when an upload or a download starts
+	TransferStarted EventType = "transfer.started"
+	// TransferFinished is emitted when an upload or a download finishes
+	TransferFinished EventType = "transfer.finished"
+	// CommandRetried is emitted when a failed command is going to be attempted again
+	CommandRetried EventType = "command.retried"
+	// ConnectionRetried is emitted when a failed connection is going to be attempted again
+	ConnectionRetried EventType = "connection.retried"
+)
+
+// Event describes a step of a run, the server being empty for the local ones
+type Event struct {
+	Time     time.Time     `json:"time"`
+	Type     EventType     `json:"type"`
+	Task     string        `json:"task,omitempty"`
+	Server   string        `json:"server,omitempty"`
+	Command  string        `json:"command,omitempty"`
+	DryRun   bool          `json:"dry_run,omitempty"`
+	ExitCode *int          `json:"exit_code,omitempty"`
+	Attempt  int           `json:"attempt,omitempty"`
+	Delay    time.Duration `json:"delay_ns,omitempty"`
+	Duration time.Duration `json:"duration_ns,omitempty"`
+	Stdout   string        `json:"stdout,omitempty"`
+	Stderr   string        `json:"stderr,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// EventSink receives the events of a run, possibly from several goroutines when tasks run in parallel
+type EventSink interface {
+	Event(event Event)
+}
+
+// AddEventSink adds a sink receiving the events of the run
+func (e *Exec) AddEventSink(sink EventSink) {
+	e.eventSinks = append(e.eventSinks, sink)
+}
+
+// emit sends the event to all sinks
+func (e *Exec) emit(event Event) {
+	if len(e.eventSinks) == 0 {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	for _, sink := range e.eventSinks {
+		sink.Event(event)
+	}
+}
+
+// jsonLines writes the events as JSON lines
+type jsonLines struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// JSONLines returns a sink writing each event as a line of JSON to w
+func JSONLines(w io.Writer) EventSink {
+	return &jsonLines{encoder: json.NewEncoder(w)}
+}
+
+func (j *jsonLines) Event(event Event) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	_ = j.encoder.Encode(event)
+}
+
+// setupLog adds the event sink selected by the --log-format and --log-file options,
+// the JSON lines being written to stderr without log file
+func (e *Exec) setupLog(format, file string) error {
+	switch format {
+	case "", "text":
+		return nil
+	case "json":
+	default:
+		return fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+
+	if file == "" {
+		e.AddEventSink(JSONLines(os.Stderr))
+		return nil
+	}
+
+	f, err := os.OpenFile(expandHome(file), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	e.logFile = f
+	e.AddEventSink(JSONLines(f))
+
+	return nil
+}
+
+// eventErr returns the message of err, empty if nil
+func eventErr(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,126 @@
+package exec
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSink keeps the received events
+type testSink struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (s *testSink) Event(event Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+}
+
+func (s *testSink) types() (types []EventType) {
+	for _, event := range s.events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestExec_AddEventSink(t *testing.T) {
+	sink := &testSink{}
+
+	e := New()
+	e.AddEventSink(sink)
+	tk := e.TaskCtx("task", func(c *Ctx) {
+		c.Local("echo out; echo err >&2")
+		c.Local("exit 3")
+	})
+	require.NoError(t, tk.run())
+
+	require.Equal(t, []EventType{TaskStarted, CommandStarted, CommandFinished, CommandStarted, CommandFinished, TaskFinished}, sink.types())
+
+	finished := sink.events[2]
+	require.Equal(t, "task", finished.Task)
+	require.Equal(t, "", finished.Server)
+	require.Equal(t, "echo out; echo err >&2", finished.Command)
+	require.Equal(t, 0, *finished.ExitCode)
+	require.Equal(t, "out\n", finished.Stdout)
+	require.Equal(t, "err\n", finished.Stderr)
+	require.NotZero(t, finished.Duration)
+	require.False(t, finished.Time.IsZero())
+
+	failed := sink.events[4]
+	require.Equal(t, 3, *failed.ExitCode)
+	require.Equal(t, "exit status 3", failed.Error)
+	require.Equal(t, "exit status 3", sink.events[5].Error)
+}
+
+func TestExec_EventsDryRun(t *testing.T) {
+	sink := &testSink{}
+
+	e := New()
+	e.DryRun(true)
+	e.AddEventSink(sink)
+	e.Server("prod1", "root@127.0.0.1:1")
+	tk := e.TaskCtx("task", func(c *Ctx) {
+		c.Remote("hostname")
+		_ = c.Upload("file", "/tmp/file")
+	}).OnServers(func() []string {
+		return []string{"prod1"}
+	})
+	require.NoError(t, tk.run())
+
+	require.Equal(t, []EventType{TaskStarted, CommandFinished, TransferFinished, TaskFinished}, sink.types())
+	for _, event := range sink.events {
+		require.Equal(t, "prod1", event.Server)
+	}
+	require.True(t, sink.events[1].DryRun)
+	require.Equal(t, "hostname", sink.events[1].Command)
+	require.Equal(t, "upload (local)file > (remote)/tmp/file", sink.events[2].Command)
+}
+
+func TestJSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	sink := JSONLines(&buf)
+
+	exitCode := 0
+	at := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	sink.Event(Event{Time: at, Type: CommandFinished, Server: "prod1", Command: "hostname", ExitCode: &exitCode, Duration: time.Second, Stdout: "prod1\n"})
+	sink.Event(Event{Time: at, Type: TaskStarted, Task: "deploy"})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Equal(t, []string{
+		`{"time":"2020-01-02T03:04:05Z","type":"command.finished","server":"prod1","command":"hostname","exit_code":0,"duration_ns":1000000000,"stdout":"prod1\n"}`,
+		`{"time":"2020-01-02T03:04:05Z","type":"task.started","task":"deploy"}`,
+	}, lines)
+}
+
+func TestExec_setupLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exec-log")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	e := New()
+	require.NoError(t, e.setupLog("text", ""))
+	require.Empty(t, e.eventSinks)
+
+	require.EqualError(t, e.setupLog("xml", ""), `unknown log format "xml", expected text or json`)
+
+	file := filepath.Join(dir, "run.log")
+	require.NoError(t, e.setupLog("json", file))
+	e.emit(Event{Type: TaskStarted, Task: "deploy"})
+	require.NoError(t, e.logFile.Close())
+
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	var event Event
+	require.NoError(t, json.Unmarshal(data, &event))
+	require.Equal(t, TaskStarted, event.Type)
+	require.Equal(t, "deploy", event.Task)
+}
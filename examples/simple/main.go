@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/fatih/color"
 	e "github.com/go-exec/exec"
+	"os"
 	"time"
 )
 
@@ -42,8 +43,26 @@ func main() {
 
 	exec.AddArgument(arg2)
 
+	//abort tasks on the first failed command and exit with a non-zero code
+	exec.ErrorPolicy(e.StopOnError)
+
+	//accept and remember the host keys of new servers, the changed ones are still rejected
+	exec.HostKeyPolicy(e.TrustOnFirstUse)
+
+	//keep trying to connect to the servers being rebooted for about a minute
+	exec.ConnectRetry(8, e.ExponentialBackoff(time.Second, 15*time.Second))
+
+	//display the run without colors, or only the errors with e.NewQuietReporter
+	//exec.Reporter(e.NewPlainReporter(os.Stdout, e.WithTimestamps(), e.WithStreamNames()))
+
+	//report the unknown {{var}} of the commands as errors, instead of running them as is
+	//exec.StrictParse(true)
+
 	exec.Set("env", "prod")
 
+	//env vars of all the commands, overridden by the servers' and tasks' ones
+	exec.Env(map[string]string{"APP_ENV": "{{env}}"})
+
 	exec.Set("bin/mysql", "mysql default")
 
 	exec.Set("test", func() interface{} { return "text" })
@@ -71,9 +90,37 @@ func main() {
 		Set("bin/mysql", "mysql qa")
 
 	exec.
-		Server("stage", "root@domain.com").
+		Server("stage", "deploy@domain.com").
+		SudoPassword(os.Getenv("STAGE_SUDO_PASSWORD")).
 		AddRole("stage")
 
+	//private servers reachable only through the bastion server
+	exec.
+		Server("bastion", "root@bastion.domain.com")
+
+	exec.
+		Server("private1", "root@10.0.0.1").
+		Via("bastion").
+		AddRole("private")
+
+	exec.
+		Server("private2", "root@10.0.0.2?jump=bastion").
+		AddRole("private")
+
+	//server resolved from its ~/.ssh/config entry, like `ssh staging1`
+	exec.
+		Server("staging1", "staging1").
+		AddRole("stage")
+
+	//servers, roles and configs can also be declared in a YAML, JSON or TOML inventory file
+	//if err := exec.LoadInventory("inventory.yml"); err != nil {
+	//	panic(err)
+	//}
+
+	//servers discovered at run time, cached for an hour or until --refresh-inventory
+	//exec.AddInventoryProvider(e.ScriptInventory("./inventory.sh"), time.Hour)
+	//exec.AddInventoryProvider(e.TerraformInventory("terraform.tfstate", "ubuntu"), time.Hour)
+
 	opt1 := exec.NewOption("opt1", "test")
 	opt2 := exec.NewOption("opt2", "test")
 
@@ -97,11 +144,11 @@ func main() {
 
 	exec.
 		Task("test1", func() {
-			//fmt.Println(exec.TaskContext.GetOption("opt1").ToString())
+			//fmt.Println(exec.TaskContext().GetOption("opt1").ToString())
 			exec.Remote("echo Git user is: " + exec.Get("localUser").String())
 			exec.Remote("ls -la /")
-			fmt.Println(exec.TaskContext.GetArgument("stage"))
-			fmt.Println(exec.TaskContext.GetArgument("arg2"))
+			fmt.Println(exec.TaskContext().GetArgument("stage"))
+			fmt.Println(exec.TaskContext().GetArgument("arg2"))
 		}).
 		ShortDescription("Running test1 task").
 		AddOption(opt1)
@@ -124,14 +171,18 @@ func main() {
 		Task("local", func() {
 			exec.Local("ls -la ~/Public; ls -la /Users/")
 			exec.Local("docker")
+			//args passed as is, without being split or expanded by a shell
+			exec.Cmd("git", "commit", "-m", "Release {{env}} by {{localUser}}").Local()
+			exec.Local("git tag -a v1 -m {{localUser | quote}}")
 		}).
 		Once().
 		ShortDescription("Running local task")
 
 	exec.
 		Task("yarn", func() {
-			exec.Local("yarn")
-		})
+			exec.Local("yarn", e.Timeout(5*time.Minute))
+		}).
+		Timeout(10 * time.Minute)
 
 	exec.
 		Task("docker", func() {
@@ -140,17 +191,53 @@ func main() {
 
 	exec.
 		Task("docker-remote", func() {
-			exec.Remote("docker")
+			exec.Remote("docker pull nginx", e.Retry(3, e.ConstantBackoff(5*time.Second)))
+		}).
+		OnServers(func() []string {
+			return []string{"prod1"}
+		})
+
+	exec.
+		Task("mysql", func() {
+			exec.Remote("{{bin/mysql}}", e.Interactive())
 		}).
+		ShortDescription("Opening a mysql shell on the server").
 		OnServers(func() []string {
 			return []string{"prod1"}
 		})
 
+	exec.
+		Task("import", func() {
+			dump, err := os.Open("dump.sql")
+			if err != nil {
+				exec.Println(err.Error())
+				return
+			}
+			defer dump.Close()
+			exec.RemoteWithInput("{{bin/mysql}}", dump)
+		}).
+		ShortDescription("Importing dump.sql on the server").
+		OnServers(func() []string {
+			return []string{"prod1"}
+		})
+
+	exec.
+		Task("cache:clear", func() {
+			exec.Remote("php bin/console cache:clear")
+			exec.RemoteAs("root", "systemctl reload php-fpm")
+		}).
+		ShortDescription("Clearing the cache as the web server user").
+		Become("www-data").
+		Env(map[string]string{"APP_DEBUG": "0"}).
+		OnServers(func() []string {
+			return []string{"stage"}
+		})
+
 	exec.
 		Task("get", func() {
 			exec.Remote(fmt.Sprintf("%s", exec.Get("bin/mysql").String()))
-			fmt.Println(exec.TaskContext.GetArgument("stage"))
-			fmt.Println(exec.TaskContext.GetArgument("arg2"))
+			fmt.Println(exec.TaskContext().GetArgument("stage"))
+			fmt.Println(exec.TaskContext().GetArgument("arg2"))
 		}).
 		ShortDescription("Testing get in different servers contexts")
 
@@ -212,12 +299,36 @@ func main() {
 
 	exec.
 		Task("servercontext:host", func() {
-			fmt.Println(exec.ServerContext.Name, exec.ServerContext.GetHost())
+			fmt.Println(exec.ServerContext().Name, exec.ServerContext().GetHost())
 		}).
 		OnServers(func() []string {
 			return []string{"prod1", "prod2"}
 		})
 
+	exec.
+		TaskCtx("onservers:parallel", func(ctx *e.Ctx) {
+			ctx.Remote("hostname")
+		}).
+		OnServers(func() []string {
+			return []string{"prod1", "prod2"}
+		}).
+		Parallel(2)
+
+	exec.
+		TaskCtx("onservers:ctx", func(ctx *e.Ctx) {
+			ctx.Cd("/var/www")
+			ctx.Setenv("APP_ENV", "{{env}}")
+			ctx.Remote("ls -la")
+			ctx.Within("releases", func() {
+				ctx.Remote("ls -t | tail -n +6 | xargs rm -rf")
+			})
+			ctx.Println("Listed /var/www on {{.Server.Name}} ({{.Server.Host}}, roles {{.Server.Roles | join \",\"}})")
+		}).
+		OnServers(func() []string {
+			return []string{"prod1", "prod2"}
+		}).
+		Parallel(2)
+
 	exec.
 		Task("onservers:read", func() {
 			fmt.Printf("`%s`\n", exec.Remote("git config --get %s", "user.name").String())
@@ -1,13 +1,18 @@
 package exec
 
 import (
+	"context"
 	"fmt"
 	"github.com/fatih/color"
+	"github.com/kevinburke/ssh_config"
+	"github.com/pkg/errors"
 	"io"
-	"io/ioutil"
+	"net/url"
 	"os"
-	"os/exec"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Exec struct {
@@ -23,30 +28,59 @@ type Exec struct {
 	Arguments map[string]*Argument
 	// Options contains all exec options
 	Options map[string]*Option
-	// ServerContext is the current active server
-	ServerContext *server
-	// TaskContext is the current executed task
-	TaskContext *task
 
-	before           map[string][]string
-	after            map[string][]string
-	serverContextF   func() []string //must return one server name
-	argumentSequence int
+	before             map[string][]string
+	after              map[string][]string
+	onFailure          map[string][]string
+	serverContextF     func() []string //must return one server name
+	argumentSequence   int
+	ctx                context.Context
+	cancel             context.CancelFunc
+	ctxMu              sync.Mutex
+	errorPolicy        errorPolicy
+	hostKeyPolicy      hostKeyPolicy
+	knownHosts         []string
+	knownHostsMu       sync.Mutex
+	connectRetry       retryPolicy
+	env                map[string]string
+	strictParse        bool
+	passphrase         passphraseFunc
+	sshConfigFiles     []string
+	sshConfigs         []*ssh_config.Config
+	jumpServers        map[string]*server
+	inventoryProviders []inventoryProvider
+	dryRun             bool
+	dryRunOutput       func(server, command string) string
+	eventSinks         []EventSink
+	reporter           Reporter
+	logFile            *os.File
+	promptMu           sync.Mutex
+	invocation         *Ctx
+	invocations        int
+	invocationMu       sync.Mutex
 }
 
 // New returns a new *Exec instance
 func New() *Exec {
-	return &Exec{
+	e := &Exec{
 		Configs:        make(map[string]*config),
 		Tasks:          make(map[string]*task),
 		Servers:        make(map[string]*server),
+		jumpServers:    make(map[string]*server),
 		TaskGroups:     make(map[string]*taskGroup),
 		Arguments:      make(map[string]*Argument),
 		Options:        make(map[string]*Option),
 		before:         make(map[string][]string),
 		after:          make(map[string][]string),
+		onFailure:      make(map[string][]string),
 		serverContextF: func() []string { return nil },
+		errorPolicy:    ContinueOnError,
+		hostKeyPolicy:  StrictHostKeys,
+		connectRetry:   retryPolicy{attempts: 3, backoff: ExponentialBackoff(time.Second, 10*time.Second)},
+		reporter:       NewColorReporter(color.Output),
 	}
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	return e
 }
 
 // Instance is the default empty exported instance of *Exec
@@ -89,6 +123,13 @@ func (e *Exec) Run() {
 				}
 			}
 		}
+		if e.onFailure[task.Name] != nil {
+			for _, ft := range e.onFailure[task.Name] {
+				if e.Tasks[ft] != nil {
+					task.onFailure = append(task.onFailure, e.Tasks[ft])
+				}
+			}
+		}
 	}
 
 	var rootTask = task{
@@ -98,13 +139,47 @@ func (e *Exec) Run() {
 	rootTask.Arguments = e.Arguments
 	rootTask.Options = mergeOptions(map[string]string{}, e.Options, rootTask.Options)
 
-	if err := run(&rootTask); err != nil {
-		_, _ = fmt.Fprintln(os.Stderr, err)
-	} else {
-		for _, s := range e.Servers {
-			_ = s.sshClient.Close()
+	stopInterrupts := e.handleInterrupts()
+	err := run(&rootTask)
+	stopInterrupts()
+
+	for _, servers := range []map[string]*server{e.Servers, e.jumpServers} {
+		for _, s := range servers {
+			if s.sshClient.connected() {
+				_ = s.sshClient.Close()
+			}
 		}
 	}
+
+	if e.logFile != nil {
+		_ = e.logFile.Close()
+	}
+
+	if err != nil {
+		e.reporter.OnError("local", err)
+		if errors.Cause(err) == ErrInterrupted {
+			os.Exit(130)
+		}
+		os.Exit(1)
+	}
+}
+
+// ErrorPolicy sets what happens by default in all tasks when a command fails,
+// either ContinueOnError (default) or StopOnError
+func (e *Exec) ErrorPolicy(policy errorPolicy) {
+	e.errorPolicy = policy
+}
+
+// DryRun enables the dry-run mode, also set by the --dry-run option:
+// the commands and transfers are printed with their servers, without running them nor connecting
+func (e *Exec) DryRun(enabled bool) {
+	e.dryRun = enabled
+}
+
+// DryRunOutput sets the func returning the stdout of the commands in dry-run mode, by server ("local" for Local);
+// by default the output is empty
+func (e *Exec) DryRunOutput(f func(server, command string) string) {
+	e.dryRunOutput = f
 }
 
 // NewArgument returns a new Argument
@@ -164,92 +239,130 @@ func (e *Exec) Set(name string, value interface{}) {
 	e.Configs[name] = &config{Name: name, value: value}
 }
 
-// Get gets a Config value either set in a Server or directly in exec
-func (e *Exec) Get(name string) *config {
-	if e.ServerContext != nil {
-		if c, ok := e.ServerContext.Configs[name]; ok {
-			return c
-		}
+// Env sets env vars for all the Local and Remote commands, overridden by the ones of the servers and tasks
+func (e *Exec) Env(env map[string]string) {
+	e.env = mergeEnv(e.env, env)
+}
+
+// ServerContext returns the current active server
+func (e *Exec) ServerContext() *server {
+	if c := e.context(); c != nil {
+		return c.server
 	}
-	if c, ok := e.Configs[name]; ok {
-		return c
+	return nil
+}
+
+// TaskContext returns the current executed task
+func (e *Exec) TaskContext() *task {
+	if c := e.context(); c != nil {
+		return c.task
 	}
 	return nil
 }
 
+// Get gets a Config value either set in a Server or directly in exec
+func (e *Exec) Get(name string) *config {
+	return e.current().Get(name)
+}
+
 // Has checks if a Config is available
 func (e *Exec) Has(name string) bool {
-	if e.ServerContext != nil {
-		if _, ok := e.ServerContext.Configs[name]; ok {
-			return true
-		}
-	}
-	_, ok := e.Configs[name]
-	return ok
+	return e.current().Has(name)
+}
+
+// HostKeyPolicy sets how the SSH host keys of all servers are verified,
+// either StrictHostKeys (default), TrustOnFirstUse or InsecureHostKeys
+func (e *Exec) HostKeyPolicy(policy hostKeyPolicy) {
+	e.hostKeyPolicy = policy
+}
+
+// KnownHosts sets the known_hosts files used to verify the SSH host keys of all servers,
+// the first one receiving the keys trusted on first use; ~/.ssh/known_hosts is used by default
+func (e *Exec) KnownHosts(files ...string) {
+	e.knownHosts = files
 }
 
 // Server adds a new Server to exec
-// dsn should be user@host:port
+// dsn should be user@host:port, optionally followed by ?jump=server to connect through another server
 func (e *Exec) Server(name string, dsn string) *server {
-	e.Servers[name] = &server{
+	var via string
+	if i := strings.Index(dsn, "?"); i != -1 {
+		if query, err := url.ParseQuery(dsn[i+1:]); err == nil {
+			via = query.Get("jump")
+		}
+		dsn = dsn[:i]
+	}
+
+	e.Servers[name] = e.newServer(name, dsn, via)
+	return e.Servers[name]
+}
+
+// newServer returns a server resolved from the ssh_config files, the dsn defaulting to the name
+func (e *Exec) newServer(name, dsn, via string) *server {
+	if dsn == "" {
+		dsn = name
+	}
+
+	s := &server{
 		Name:      name,
 		Dsn:       dsn,
 		Configs:   make(map[string]*config),
 		sshClient: &sshClient{},
+		via:       via,
 	}
-	return e.Servers[name]
+	e.resolveSSHConfig(s)
+
+	return s
 }
 
 // Task inherits the exec Arguments and can override and/or have new Options
 // it accepts a name and a func; the func content is executed on each command execution
+// use TaskCtx for a func receiving the execution context
 func (e *Exec) Task(name string, f func()) *task {
+	t := e.TaskCtx(name, func(*Ctx) { f() })
+	t.implicit = true
+	return t
+}
+
+// TaskCtx declares a task like Task, its func receiving the execution context of each invocation;
+// the exec helpers only act on the invocations running sequentially, a task running in parallel must use its Ctx
+func (e *Exec) TaskCtx(name string, taskF func(*Ctx)) *task {
 	e.Tasks[name] = &task{
 		Name:            name,
 		Arguments:       make(map[string]*Argument),
 		Options:         make(map[string]*Option),
 		exec:            e,
+		fn:              taskF,
 		removeArguments: make(map[string]string),
 		removeOptions:   make(map[string]string),
 		serverContextF: func() []string {
 			return nil
 		},
 	}
-	e.Tasks[name].run = func() {
-		// set task context
-		e.TaskContext = e.Tasks[name]
+	e.Tasks[name].run = func() error {
+		t := e.Tasks[name]
 
-		run, onServers := e.shouldIRun()
+		run, onServers := e.shouldIRun(t)
 
 		//skip tasks's server checking if requested
 		if run && len(onServers) > 0 {
-			for _, server := range e.Servers {
-				for _, onServer := range onServers {
-					if (server.Name == onServer || server.HasRole(onServer)) && e.Servers[onServer] != nil {
-						// set server context
-						e.ServerContext = server
-
-						color.White("➤ Executing task %s on server %s", color.YellowString(name), color.GreenString(fmt.Sprintf("[%s]", server.Name)))
-
-						//execute task's func
-						f()
-
-						//reset server context
-						e.ServerContext = nil
-					}
-				}
+			err := t.runOnServers(e.serversFor(onServers), taskF)
+			if err != nil {
+				t.rollback(t.touched())
 			}
-
+			return err
 		} else if run && len(onServers) == 0 {
-			color.White("➤ Executing task %s", color.YellowString(name))
-
 			//execute task's func
-			f()
-		} else {
-			e.taskNotAllowedToRunPrint(onServers, name)
+			err := t.runLocally(taskF)
+			if err != nil {
+				t.rollback(nil)
+			}
+			return err
 		}
 
-		//reset task context
-		e.TaskContext = nil
+		e.taskNotAllowedToRunPrint(onServers, name)
+
+		return nil
 	}
 	return e.Tasks[name]
 }
@@ -264,8 +377,10 @@ func (e *Exec) TaskGroup(name string, tasks ...string) *taskGroup {
 			removeArguments: make(map[string]string),
 			removeOptions:   make(map[string]string),
 			exec:            e,
-			run: func() {
-				color.White("➤ Executing task group %s", color.YellowString(name))
+			run: func() (err error) {
+				e.reporter.OnMessage("", "➤ Executing task group "+name)
+
+				var touched []*server
 				for _, task := range tasks {
 					if e.Tasks[task] == nil {
 						continue
@@ -275,18 +390,24 @@ func (e *Exec) TaskGroup(name string, tasks ...string) *taskGroup {
 						continue
 					}
 
-					//set task context
-					e.TaskContext = e.Tasks[task]
-
-					e.Tasks[task].run()
+					err = e.Tasks[task].run()
 
 					if e.Tasks[task].once && !e.Tasks[task].executedOnce {
 						e.Tasks[task].executedOnce = true
 					}
 
-					//reset task context
-					e.TaskContext = nil
+					touched = appendServers(touched, e.Tasks[task].touched()...)
+
+					if err != nil {
+						break
+					}
+				}
+
+				if err != nil {
+					e.TaskGroups[name].task.rollback(touched)
 				}
+
+				return err
 			},
 		},
 	}
@@ -296,79 +417,23 @@ func (e *Exec) TaskGroup(name string, tasks ...string) *taskGroup {
 
 // Local runs a local command and displays/returns the output for further usage, for example in a Task func
 func (e *Exec) Local(command string, args ...interface{}) (o Output) {
-	command = e.Parse(fmt.Sprintf(command, args...))
-
-	color.Green("[%s] %s %s", "local", ">", color.WhiteString("`%s`", command))
-
-	cmd := exec.Command("/bin/sh", "-c", command)
-	if e.TaskContext != nil && e.TaskContext.Dir != "" {
-		cmd.Dir = e.TaskContext.Dir
-	}
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		o.err = err
-		color.Red("[%s] %s %q", "local", "<", o.err)
-		return o
-	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		o.err = err
-		color.Red("[%s] %s %q", "local", "<", o.err)
-		return o
-	}
-
-	err = cmd.Start()
-	if err != nil {
-		o.err = err
-		color.Red("[%s] %s %q", "local", "<", o.err)
-		return o
-	}
-
-	output := ""
-	buf := make([]byte, 1024)
-
-	n, err := stdout.Read(buf)
-
-	if err != nil {
-		o.err = err
-	} else {
-		color.Green("[%s] %s\n", "local", "<")
-		for _, v := range buf[:n] {
-			fmt.Printf("%c", v)
-		}
-		output = string(buf[:n])
-	}
-	for err == nil {
-		n, err = stdout.Read(buf)
-		output += string(buf[:n])
-		for _, v := range buf[:n] {
-			fmt.Printf("%c", v)
-		}
-		if err != nil && err != io.EOF {
-			o.err = err
-		}
-	}
-
-	o.text = strings.TrimSpace(output)
-
-	if len(o.text) == 0 {
-		color.Red("[%s] %s\n", "local", "<")
-		bytesB, _ := ioutil.ReadAll(stderr)
-		fmt.Printf("%s\n", strings.TrimSpace(string(bytesB)))
-	}
+	return e.current().Local(command, args...)
+}
 
-	err = cmd.Wait()
-	if err != nil {
-		color.Red("[%s] %s %q", "local", "<", err)
-	}
+// Cmd returns a command running name with args, each arg being passed as a single word,
+// to execute with its Local or Remote method
+func (e *Exec) Cmd(name string, args ...string) *cmd {
+	return e.current().Cmd(name, args...)
+}
 
-	return o
+// LocalWithInput runs a local command like Local, with input as its stdin
+func (e *Exec) LocalWithInput(command string, input io.Reader, args ...interface{}) (o Output) {
+	return e.current().LocalWithInput(command, input, args...)
 }
 
 // Println parses a text template, if founds a {{ var }}, it automatically runs the Get(var) on it
 func (e *Exec) Println(text string) {
-	fmt.Println(e.Parse(text))
+	e.current().Println(text)
 }
 
 // OnServers sets the server context dynamically
@@ -378,52 +443,27 @@ func (e *Exec) OnServers(f func() []string) {
 
 // Remote runs a command with args, in the ServerContext
 func (e *Exec) Remote(command string, args ...interface{}) (o Output) {
-	run, onServers := e.shouldIRun()
-
-	if !run {
-		e.commandNotAllowedToRunPrint(onServers, fmt.Sprintf(command, args...))
-		return o
-	}
-
-	if e.ServerContext != nil {
-		return e.remoteRun(fmt.Sprintf(command, args...), e.ServerContext)
-	}
-
-	return o
+	return e.current().Remote(command, args...)
 }
 
-// Upload uploads a file or directory from local to remote, using native scp binary
-func (e *Exec) Upload(local, remote string) {
-	run, onServers := e.shouldIRun()
-
-	if !run {
-		e.commandNotAllowedToRunPrint(onServers, fmt.Sprintf("scp (local)%s > (remote)%s", local, remote))
-	}
-
-	var args = []string{"scp", "-o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null -r"}
-	if e.ServerContext.key != nil {
-		args = append(args, "-i "+*e.ServerContext.key)
-	}
-	args = append(args, local, e.ServerContext.Dsn+":"+remote)
-
-	e.Local(strings.Join(args, " "))
+// RemoteWithInput runs a command in the ServerContext like Remote, with input as its stdin
+func (e *Exec) RemoteWithInput(command string, input io.Reader, args ...interface{}) (o Output) {
+	return e.current().RemoteWithInput(command, input, args...)
 }
 
-// Download downloads a file or directory from remote to local, using native scp binary
-func (e *Exec) Download(remote, local string) {
-	run, onServers := e.shouldIRun()
-
-	if !run {
-		e.commandNotAllowedToRunPrint(onServers, fmt.Sprintf("scp (remote)%s > (local)%s", local, remote))
-	}
+// RemoteAs runs a command in the ServerContext like Remote, as user with sudo
+func (e *Exec) RemoteAs(user, command string, args ...interface{}) (o Output) {
+	return e.current().RemoteAs(user, command, args...)
+}
 
-	var args = []string{"scp", "-o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null -r"}
-	if e.ServerContext.key != nil {
-		args = append(args, "-i "+*e.ServerContext.key)
-	}
-	args = append(args, e.ServerContext.Dsn+":"+remote, local)
+// Upload uploads a file or directory from local to remote over SFTP
+func (e *Exec) Upload(local, remote string) error {
+	return e.current().Upload(local, remote)
+}
 
-	e.Local(strings.Join(args, " "))
+// Download downloads a file or directory from remote to local over SFTP
+func (e *Exec) Download(remote, local string) error {
+	return e.current().Download(remote, local)
 }
 
 // Before sets tasks to run before task
@@ -444,71 +484,17 @@ func (e *Exec) After(task string, tasksAfter ...string) {
 	}
 }
 
-// remoteRun executes a command on a specific server
-func (e *Exec) remoteRun(command string, server *server) (o Output) {
-	e.ServerContext = server
-	command = e.Parse(command)
-
-	color.Green("[%s] %s %s", server.Name, ">", color.WhiteString("`%s`", command))
-
-	if !server.sshClient.connOpened {
-		err := server.sshClient.Connect(server.Dsn)
-		if err != nil {
-			color.Red("[%s] %s %q", "local", "<", err)
-			o.err = err
-		}
-	}
-
-	if server.sshClient.connOpened {
-		err := server.sshClient.Run(command)
-		if err != nil {
-			o.err = err
-			color.Red("[%s] %s %q", server.Name, "<", err)
-		}
-
-		output := ""
-		buf := make([]byte, 1024)
-
-		n, err := server.sshClient.remoteStdout.Read(buf)
-
-		if err != nil {
-			o.err = err
-		} else {
-			color.Green("[%s] %s\n", server.Name, "<")
-			for _, v := range buf[:n] {
-				fmt.Printf("%c", v)
-			}
-			output = string(buf[:n])
-		}
-		for err == nil {
-			n, err = server.sshClient.remoteStdout.Read(buf)
-			output += string(buf[:n])
-			for _, v := range buf[:n] {
-				fmt.Printf("%c", v)
-			}
-			if err != nil && err != io.EOF {
-				o.err = err
-			}
-		}
-
-		o.text = strings.TrimSpace(output)
-
-		if len(o.text) == 0 {
-			color.Red("[%s] %s\n", server.Name, "<")
-			bytesB, _ := ioutil.ReadAll(server.sshClient.remoteStderr)
-			fmt.Printf("%s\n", strings.TrimSpace(string(bytesB)))
-		}
-
-		err = server.sshClient.Wait()
-		if err != nil {
-			color.Red("[%s] %s %q", server.Name, "<", err)
+// OnFailure sets tasks to run when task fails under its error policy, on the servers the task was executed on
+func (e *Exec) OnFailure(task string, tasksOnFailure ...string) {
+	for _, tf := range tasksOnFailure {
+		if !contains(e.onFailure[task], tf) {
+			e.onFailure[task] = append(e.onFailure[task], tf)
 		}
 	}
-
-	return o
 }
 
-func (e *Exec) shouldIRun() (run bool, onServers []string) {
+// shouldIRun checks if task t is allowed to run and on which servers
+func (e *Exec) shouldIRun(t *task) (run bool, onServers []string) {
 	run = true
 
 	//default values if serverContextF is set
@@ -517,27 +503,27 @@ func (e *Exec) shouldIRun() (run bool, onServers []string) {
 	}
 
 	//inside a task
-	if e.TaskContext != nil {
+	if t != nil {
 		//task has a serverContextF
-		if s := e.TaskContext.serverContextF(); len(s) > 0 {
+		if s := t.serverContextF(); len(s) > 0 {
 			onServers = s
 		}
 
 		//task needs to run only on some servers
-		if len(e.TaskContext.onlyOnServers) > 0 {
+		if len(t.onlyOnServers) > 0 {
 			run = false
 			for _, oS := range onServers {
-				for _, oOS := range e.TaskContext.onlyOnServers {
+				for _, oOS := range t.onlyOnServers {
 					//task on server matches only on servers
 					if oS == oOS {
 						run = true
 					}
 				}
 			}
-			onServers = e.TaskContext.onlyOnServers
+			onServers = t.onlyOnServers
 		}
 
-		if e.TaskContext.once && e.TaskContext.executedOnce {
+		if t.once && t.executedOnce {
 			run = false
 		}
 	}
@@ -545,24 +531,100 @@ func (e *Exec) shouldIRun() (run bool, onServers []string) {
 	return run, onServers
 }
 
+// connect opens the SSH connection to s if not already opened, through its jump servers if any;
+// jumped is the chain of servers waiting for s to be connected; it gives up once ctx is done
+func (e *Exec) connect(ctx context.Context, s *server, jumped []string) error {
+	if contains(jumped, s.Name) {
+		return fmt.Errorf("jump servers loop %s > %s", strings.Join(jumped, " > "), s.Name)
+	}
+
+	connect, done, err := s.sshClient.startConnecting(ctx)
+	if !connect {
+		return err
+	}
+	defer done()
+
+	callback, err := e.hostKeyCallback(s)
+	if err != nil {
+		return err
+	}
+	s.sshClient.hostKeyCallback = callback
+	s.sshClient.hostKeyAlgorithms = e.hostKeyAlgorithms(s)
+
+	if s.sshClient.auth == nil {
+		if s.sshClient.auth, s.sshClient.authTried, err = e.authMethods(s); err != nil {
+			return err
+		}
+	}
+
+	s.sshClient.connectRetry = e.connectRetry
+	s.sshClient.onConnectRetry = func(attempt int, delay time.Duration, err error) {
+		e.reporter.OnMessage(s.Name, fmt.Sprintf("connection attempt %d/%d failed, retrying in %s: %v", attempt, e.connectRetry.attempts, delay, err))
+		e.emit(Event{Type: ConnectionRetried, Server: s.Name, Attempt: attempt, Delay: delay, Error: err.Error()})
+	}
+
+	if s.via == "" {
+		return s.sshClient.Connect(ctx, s.Dsn)
+	}
+
+	jump, ok := e.Servers[s.via]
+	if !ok {
+		jump, ok = e.jumpServers[s.via]
+	}
+	if !ok {
+		return fmt.Errorf("jump server %s of server %s not found", s.via, s.Name)
+	}
+	if err := e.connect(ctx, jump, append(jumped, s.Name)); err != nil {
+		return err
+	}
+
+	e.reporter.OnCommand(s.Name, "connect via "+jump.Name)
+
+	return s.sshClient.ConnectWith(ctx, s.Dsn, jump.sshClient.DialThrough)
+}
+
+// serversFor returns the servers matching onServers by name or role
+func (e *Exec) serversFor(onServers []string) (servers []*server) {
+	var names []string
+	for name := range e.Servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// each server runs once, even if matching several names and roles, in the order they are requested
+	added := make(map[string]bool)
+	for _, onServer := range onServers {
+		for _, name := range names {
+			server := e.Servers[name]
+			if (server.Name == onServer || server.HasRole(onServer)) && !added[name] {
+				added[name] = true
+				servers = append(servers, server)
+			}
+		}
+	}
+	return servers
+}
+
 func (e *Exec) commandNotAllowedToRunPrint(onServers []string, command string) {
-	fmt.Printf("%s%s%s\n", color.CyanString("[local] > Command `"), color.WhiteString(command), color.CyanString("` can run only on %s", onServers))
+	e.reporter.OnMessage("local", fmt.Sprintf("Command `%s` can run only on %s", command, onServers))
 }
 
 func (e *Exec) taskNotAllowedToRunPrint(onServers []string, task string) {
-	fmt.Printf("%s%s%s\n", color.CyanString("[local] > Task `"), color.WhiteString(task), color.CyanString("` can run only on %s", onServers))
+	e.reporter.OnMessage("local", fmt.Sprintf("Task `%s` can run only on %s", task, onServers))
 }
 
 // onStart task setup
-func (e *Exec) onStart() {
+func (e *Exec) onStart() error {
 	if task, ok := e.Tasks["onStart"]; ok {
-		task.run()
+		return task.run()
 	}
+	return nil
 }
 
 // onEnd task setup
-func (e *Exec) onEnd() {
+func (e *Exec) onEnd() error {
 	if task, ok := e.Tasks["onEnd"]; ok {
-		task.run()
+		return task.run()
 	}
+	return nil
 }
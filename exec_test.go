@@ -1,11 +1,30 @@
 package exec
 
 import (
+	"context"
 	"github.com/go-exec/exec/ssh_mock"
 	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
+// TestMain runs the tests in an empty home dir, so the user's ssh config, keys and known hosts aren't read
+func TestMain(m *testing.M) {
+	home, err := ioutil.TempDir("", "exec-home")
+	if err != nil {
+		panic(err)
+	}
+	_ = os.Setenv("HOME", home)
+
+	code := m.Run()
+	_ = os.RemoveAll(home)
+	os.Exit(code)
+}
+
 func TestNew(t *testing.T) {
 	e := New()
 
@@ -195,7 +214,7 @@ func TestExec_Get(t *testing.T) {
 			}
 
 			if testCase.serverCtx != nil {
-				e.ServerContext = testCase.serverCtx
+				defer e.enter(e.newCtx(nil, testCase.serverCtx))()
 			}
 
 			require.Equal(t, e.Get(testCase.name), testCase.cfg)
@@ -252,7 +271,7 @@ func TestExec_Has(t *testing.T) {
 			}
 
 			if testCase.serverCtx != nil {
-				e.ServerContext = testCase.serverCtx
+				defer e.enter(e.newCtx(nil, testCase.serverCtx))()
 			}
 
 			require.Equal(t, e.Has(testCase.name), testCase.expectedResult)
@@ -274,6 +293,31 @@ func TestExec_Server(t *testing.T) {
 	require.Equal(t, cfg, e.Servers[cfg.Name])
 }
 
+func TestExec_serversFor(t *testing.T) {
+	e := New()
+	e.Server("web1", "root@web1").AddRole("web")
+	e.Server("web2", "root@web2").AddRole("web").AddRole("web1")
+	e.Server("db", "root@db").AddRole("db")
+
+	testCases := []struct {
+		onServers []string
+		expected  []string
+	}{
+		{onServers: []string{"web"}, expected: []string{"web1", "web2"}},
+		{onServers: []string{"db", "web1"}, expected: []string{"db", "web1", "web2"}},
+		{onServers: []string{"web1", "web"}, expected: []string{"web1", "web2"}},
+		{onServers: []string{"unknown"}},
+	}
+
+	for _, tc := range testCases {
+		var names []string
+		for _, s := range e.serversFor(tc.onServers) {
+			names = append(names, s.Name)
+		}
+		require.Equal(t, tc.expected, names)
+	}
+}
+
 func TestExec_Task(t *testing.T) {
 	e := New()
 
@@ -397,7 +441,21 @@ func TestExec_Remote(t *testing.T) {
 				command: `echo hello`,
 			},
 			wantO: Output{
-				text: "hello",
+				text:   "hello",
+				stdout: "hello\n",
+			},
+		},
+		{
+			name: "stdout, stderr and exit code",
+			args: args{
+				command: `echo %s; echo err >&2; exit 2`,
+				args:    []interface{}{"out"},
+			},
+			wantO: Output{
+				text:     "out",
+				stdout:   "out\n",
+				stderr:   "err\n",
+				exitCode: 2,
 			},
 		},
 	}
@@ -414,11 +472,17 @@ func TestExec_Remote(t *testing.T) {
 
 			s.sshClient.WithConnection(conn)
 
-			e.ServerContext = s
+			defer e.enter(e.newCtx(nil, s))()
 
 			gotO := e.Remote(testCase.args.command, testCase.args.args...)
 
-			require.Equal(t, testCase.wantO, gotO, "Remote() = %v, want %v", gotO, testCase.wantO)
+			// the duration is the only field varying between runs
+			gotO.duration = 0
+			if gotO.err != nil {
+				require.Equal(t, testCase.wantO.exitCode, exitCode(gotO.err))
+				gotO.err = nil
+			}
+			require.Equal(t, testCase.wantO, gotO)
 		})
 	}
 }
@@ -439,7 +503,30 @@ func TestExec_Local(t *testing.T) {
 				command: `echo hello`,
 			},
 			wantO: Output{
-				text: "hello",
+				text:   "hello",
+				stdout: "hello\n",
+			},
+		},
+		{
+			name: "separated stdout and stderr local test",
+			args: args{
+				command: `echo out; echo err >&2`,
+			},
+			wantO: Output{
+				text:   "out",
+				stdout: "out\n",
+				stderr: "err\n",
+			},
+		},
+		{
+			name: "exit code local test",
+			args: args{
+				command: `echo %s >&2; exit %d`,
+				args:    []interface{}{"failed", 3},
+			},
+			wantO: Output{
+				stderr:   "failed\n",
+				exitCode: 3,
 			},
 		},
 	}
@@ -449,7 +536,150 @@ func TestExec_Local(t *testing.T) {
 
 			gotO := e.Local(testCase.args.command, testCase.args.args...)
 
-			require.Equal(t, testCase.wantO, gotO, "Remote() = %v, want %v", gotO, testCase.wantO)
+			require.True(t, gotO.Duration() > 0)
+			require.Equal(t, testCase.wantO.exitCode != 0, gotO.HasError())
+
+			gotO.duration = 0
+			gotO.err = nil
+
+			require.Equal(t, testCase.wantO, gotO, "Local() = %v, want %v", gotO, testCase.wantO)
+		})
+	}
+}
+
+func TestExec_ServerWithJump(t *testing.T) {
+	e := New()
+
+	s := e.Server("private", "root@10.0.0.2:2222?jump=bastion")
+
+	require.Equal(t, "root@10.0.0.2:2222", s.Dsn)
+	require.Equal(t, "bastion", s.via)
+	require.Equal(t, "10.0.0.2", s.GetHost())
+}
+
+func TestExec_connect(t *testing.T) {
+	type testCase struct {
+		test    string
+		servers map[string]string
+		err     string
+	}
+
+	testCases := []testCase{
+		{
+			test: "unknown jump server",
+			servers: map[string]string{
+				"private": "root@10.0.0.2?jump=bastion",
+			},
+			err: "jump server bastion of server private not found",
+		},
+		{
+			test: "jump servers loop",
+			servers: map[string]string{
+				"private": "root@10.0.0.2?jump=bastion",
+				"bastion": "root@10.0.0.1?jump=gateway",
+				"gateway": "root@10.0.0.0?jump=private",
+			},
+			err: "jump servers loop private > bastion > gateway > private",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.test, func(t *testing.T) {
+			e := New()
+			e.HostKeyPolicy(InsecureHostKeys)
+
+			for name, dsn := range testCase.servers {
+				e.Server(name, dsn)
+			}
+
+			require.EqualError(t, e.connect(context.Background(), e.Servers["private"], nil), testCase.err)
 		})
 	}
 }
+
+func TestExec_DryRunHelpers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exec-dry-run")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	e := New()
+	e.DryRun(true)
+	destination := filepath.Join(dir, "destination")
+
+	e.LocalTemplateFile(filepath.Join(dir, "missing.tmpl"), destination, nil)
+	e.UploadTemplateStringSudo("content", "/etc/content")
+	e.ReplaceInRemoteFile("/etc/hosts", "a", "b")
+
+	_, err = os.Stat(destination)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestExec_RemoteTimeout(t *testing.T) {
+	server := ssh_mock.NewServer(t)
+	defer server.Shutdown()
+	conn := server.Dial(ssh_mock.ClientConfig())
+	defer conn.Close()
+
+	e := New()
+	s := e.Server("mock", "")
+	s.sshClient.WithConnection(conn)
+	defer e.enter(e.newCtx(nil, s))()
+
+	start := time.Now()
+	o := e.Remote("sleep %d", 5, Timeout(100*time.Millisecond))
+	require.Equal(t, ErrTimeout, o.Err())
+	require.True(t, time.Since(start) < 5*time.Second)
+
+	require.Equal(t, "done", e.Remote("echo done").String())
+}
+
+func TestExec_RemoteWithInput(t *testing.T) {
+	server := ssh_mock.NewServer(t)
+	defer server.Shutdown()
+	conn := server.Dial(ssh_mock.ClientConfig())
+	defer conn.Close()
+
+	e := New()
+	s := e.Server("mock", "")
+	s.sshClient.WithConnection(conn)
+	defer e.enter(e.newCtx(nil, s))()
+
+	require.Equal(t, "a\nb", e.RemoteWithInput("cat", strings.NewReader("a\nb\n")).String())
+}
+
+func TestExec_RemoteWithoutPty(t *testing.T) {
+	server := ssh_mock.NewServer(t)
+	defer server.Shutdown()
+	conn := server.Dial(ssh_mock.ClientConfig())
+	defer conn.Close()
+
+	e := New()
+	s := e.Server("mock", "")
+	s.sshClient.WithConnection(conn)
+	defer e.enter(e.newCtx(nil, s))()
+
+	o := e.Remote("echo out; echo err >&2; exit 3")
+	require.Equal(t, "out\n", o.Stdout())
+	require.Equal(t, "err\n", o.Stderr())
+	require.Equal(t, 3, o.ExitCode())
+
+	o = e.Remote("test -t 1 && echo tty", Pty())
+	require.Equal(t, "tty", o.String())
+}
+
+func TestExec_RemoteEnv(t *testing.T) {
+	server := ssh_mock.NewServer(t)
+	defer server.Shutdown()
+	conn := server.Dial(ssh_mock.ClientConfig())
+	defer conn.Close()
+
+	e := New()
+	e.Env(map[string]string{"GREETING": "hello"})
+	s := e.Server("mock", "").Env(map[string]string{"NAME": "it's {{name}}"})
+	s.Set("name", "mock")
+	s.sshClient.WithConnection(conn)
+	defer e.enter(e.newCtx(nil, s))()
+
+	e.Cd("/tmp")
+	require.Equal(t, "/tmp hello it's mock", e.Remote("echo `pwd` $GREETING $NAME").String())
+}
@@ -1,16 +1,20 @@
 module github.com/go-exec/exec
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/fatih/color v1.7.0
+	github.com/kevinburke/ssh_config v1.1.0
 	github.com/kr/pretty v0.1.0 // indirect
 	github.com/mattn/go-colorable v0.0.9 // indirect
 	github.com/mattn/go-isatty v0.0.4 // indirect
 	github.com/pkg/errors v0.8.1
+	github.com/pkg/sftp v1.13.5
 	github.com/satori/go.uuid v1.2.0
-	github.com/stretchr/testify v1.4.0
-	golang.org/x/crypto v0.0.0-20170118185426-b8a2a83acfe6
-	golang.org/x/sys v0.0.0-20161214190518-d75a52659825 // indirect
+	github.com/stretchr/testify v1.7.0
+	golang.org/x/crypto v0.0.0-20211215153901-e495a2d5b3d3
+	golang.org/x/sys v0.0.0-20211216021012-1d35b9e2eb4e
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
+	gopkg.in/yaml.v2 v2.2.2
 )
 
 go 1.13
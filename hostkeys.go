@@ -0,0 +1,195 @@
+package exec
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+type hostKeyPolicy int
+
+const (
+	// StrictHostKeys accepts only the host keys found in the known_hosts files
+	StrictHostKeys hostKeyPolicy = iota + 1
+	// TrustOnFirstUse accepts the unknown host keys and appends them to the first known_hosts file,
+	// still rejecting the changed ones
+	TrustOnFirstUse
+	// InsecureHostKeys accepts any host key, it should be used only for throwaway servers
+	InsecureHostKeys
+)
+
+// defaultKnownHosts returns the user's known_hosts file
+func defaultKnownHosts() string {
+	return filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+}
+
+// hostKeyCallback returns the callback verifying the host key of s,
+// according to its pinned keys or else to its or the exec's policy and known_hosts files
+func (e *Exec) hostKeyCallback(s *server) (ssh.HostKeyCallback, error) {
+	if len(s.hostKeys) > 0 {
+		return pinnedHostKeys(s.hostKeys), nil
+	}
+
+	files := e.knownHostsFiles(s)
+
+	switch e.policy(s) {
+	case InsecureHostKeys:
+		return ssh.InsecureIgnoreHostKey(), nil
+	case TrustOnFirstUse:
+		return e.trustOnFirstUse(files)
+	default:
+		return knownhosts.New(files...)
+	}
+}
+
+// hostKeyAlgorithms returns a func listing the algorithms of the host keys known for an address,
+// for the server to present one of them instead of another one seen as a changed key;
+// it is nil when the host key is not verified with the known_hosts files
+func (e *Exec) hostKeyAlgorithms(s *server) func(address string) []string {
+	if len(s.hostKeys) > 0 || e.policy(s) == InsecureHostKeys {
+		return nil
+	}
+
+	return func(address string) []string {
+		callback, err := knownhosts.New(e.knownHostsFiles(s)...)
+		if err != nil {
+			return nil
+		}
+		return knownHostKeyAlgorithms(callback, address)
+	}
+}
+
+// policy returns the host key policy of s, or else of the exec
+func (e *Exec) policy(s *server) hostKeyPolicy {
+	if s.hostKeyPolicy != 0 {
+		return s.hostKeyPolicy
+	}
+	return e.hostKeyPolicy
+}
+
+// knownHostsFiles returns the known_hosts files of s, or else of the exec, or else the user's one
+func (e *Exec) knownHostsFiles(s *server) (files []string) {
+	knownHosts := s.knownHosts
+	if len(knownHosts) == 0 {
+		knownHosts = e.knownHosts
+	}
+	if len(knownHosts) == 0 {
+		knownHosts = []string{defaultKnownHosts()}
+	}
+	for _, file := range knownHosts {
+		files = append(files, expandHome(file))
+	}
+	return files
+}
+
+// hostKeyAlgorithmsOrder lists the host key algorithms by preference, with the type of their keys
+var hostKeyAlgorithmsOrder = [][2]string{
+	{ssh.KeyAlgoED25519, ssh.KeyAlgoED25519},
+	{ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA256},
+	{ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA384},
+	{ssh.KeyAlgoECDSA521, ssh.KeyAlgoECDSA521},
+	{ssh.SigAlgoRSASHA2512, ssh.KeyAlgoRSA},
+	{ssh.SigAlgoRSASHA2256, ssh.KeyAlgoRSA},
+	{ssh.KeyAlgoRSA, ssh.KeyAlgoRSA},
+	{ssh.KeyAlgoDSA, ssh.KeyAlgoDSA},
+}
+
+// knownHostKeyAlgorithms returns the algorithms of the host keys known by callback for address,
+// found by checking a key that can't be known; it is nil if none is known
+func knownHostKeyAlgorithms(callback ssh.HostKeyCallback, address string) (algorithms []string) {
+	probe, err := ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+	if err != nil {
+		return nil
+	}
+
+	keyErr, ok := callback(address, &net.TCPAddr{IP: net.IPv4zero}, probe).(*knownhosts.KeyError)
+	if !ok {
+		return nil
+	}
+
+	known := make(map[string]bool)
+	for _, key := range keyErr.Want {
+		known[key.Key.Type()] = true
+	}
+	for _, algorithm := range hostKeyAlgorithmsOrder {
+		if known[algorithm[1]] {
+			algorithms = append(algorithms, algorithm[0])
+		}
+	}
+	return algorithms
+}
+
+// trustOnFirstUse returns a callback accepting the known and the unknown host keys, the later being appended to files[0]
+func (e *Exec) trustOnFirstUse(files []string) (ssh.HostKeyCallback, error) {
+	if err := os.MkdirAll(filepath.Dir(files[0]), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(files[0], os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	_ = f.Close()
+
+	callback, err := knownhosts.New(files...)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		if keyErr, ok := err.(*knownhosts.KeyError); !ok || len(keyErr.Want) > 0 {
+			return err
+		}
+
+		e.knownHostsMu.Lock()
+		defer e.knownHostsMu.Unlock()
+
+		f, err := os.OpenFile(files[0], os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if _, err := fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)); err != nil {
+			return err
+		}
+
+		e.reporter.OnMessage("local", fmt.Sprintf("Permanently added %s (%s) to %s", hostname, ssh.FingerprintSHA256(key), files[0]))
+
+		return nil
+	}, nil
+}
+
+// pinnedHostKeys returns a callback accepting only the pinned host keys,
+// given as SHA256 fingerprints or authorized_keys lines
+func pinnedHostKeys(pins []string) ssh.HostKeyCallback {
+	var (
+		fingerprints = make(map[string]bool)
+		keys         [][]byte
+	)
+	for _, pin := range pins {
+		if key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pin)); err == nil {
+			keys = append(keys, key.Marshal())
+		} else {
+			fingerprints[pin] = true
+		}
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if fingerprints[ssh.FingerprintSHA256(key)] || fingerprints[ssh.FingerprintLegacyMD5(key)] {
+			return nil
+		}
+		for _, k := range keys {
+			if bytes.Equal(k, key.Marshal()) {
+				return nil
+			}
+		}
+		return fmt.Errorf("host key %s of %s is not pinned", ssh.FingerprintSHA256(key), hostname)
+	}
+}
@@ -0,0 +1,130 @@
+package exec
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	return key
+}
+
+func TestExec_hostKeyCallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exec-hostkeys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	key := newTestHostKey(t)
+	otherKey := newTestHostKey(t)
+	addr := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 22}
+
+	t.Run("strict rejects unknown keys", func(t *testing.T) {
+		knownHosts := filepath.Join(dir, "strict")
+		require.NoError(t, ioutil.WriteFile(knownHosts, nil, 0600))
+
+		e := New()
+		e.KnownHosts(knownHosts)
+
+		callback, err := e.hostKeyCallback(e.Server("s", "root@host"))
+		require.NoError(t, err)
+		require.Error(t, callback("host:22", addr, key))
+	})
+
+	t.Run("strict fails without known_hosts file", func(t *testing.T) {
+		e := New()
+		e.KnownHosts(filepath.Join(dir, "missing"))
+
+		_, err := e.hostKeyCallback(e.Server("s", "root@host"))
+		require.Error(t, err)
+	})
+
+	t.Run("trust on first use", func(t *testing.T) {
+		knownHosts := filepath.Join(dir, "tofu", "known_hosts")
+
+		e := New()
+		e.HostKeyPolicy(TrustOnFirstUse)
+		e.KnownHosts(knownHosts)
+
+		callback, err := e.hostKeyCallback(e.Server("s", "root@host"))
+		require.NoError(t, err)
+		require.NoError(t, callback("host:22", addr, key))
+
+		content, err := ioutil.ReadFile(knownHosts)
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(string(content), "host ssh-ed25519 "))
+
+		callback, err = e.hostKeyCallback(e.Server("s", "root@host"))
+		require.NoError(t, err)
+		require.NoError(t, callback("host:22", addr, key))
+		require.Error(t, callback("host:22", addr, otherKey))
+	})
+
+	t.Run("server policy overrides exec policy", func(t *testing.T) {
+		e := New()
+		e.KnownHosts(filepath.Join(dir, "missing"))
+
+		callback, err := e.hostKeyCallback(e.Server("s", "root@host").HostKeyPolicy(InsecureHostKeys))
+		require.NoError(t, err)
+		require.NoError(t, callback("host:22", addr, key))
+	})
+
+	t.Run("pinned keys", func(t *testing.T) {
+		e := New()
+		e.HostKeyPolicy(InsecureHostKeys)
+
+		callback, err := e.hostKeyCallback(e.Server("fingerprint", "root@host").HostKey(ssh.FingerprintSHA256(key)))
+		require.NoError(t, err)
+		require.NoError(t, callback("host:22", addr, key))
+		require.Error(t, callback("host:22", addr, otherKey))
+
+		callback, err = e.hostKeyCallback(e.Server("authorized", "root@host").HostKey(string(ssh.MarshalAuthorizedKey(key))))
+		require.NoError(t, err)
+		require.NoError(t, callback("host:22", addr, key))
+		require.Error(t, callback("host:22", addr, otherKey))
+	})
+}
+
+func TestExec_hostKeyAlgorithms(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exec-hostkeys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	rsaPub, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+
+	knownHosts := filepath.Join(dir, "known_hosts")
+	require.NoError(t, ioutil.WriteFile(knownHosts, []byte(
+		knownhosts.Line([]string{"ed25519.host"}, newTestHostKey(t))+"\n"+
+			knownhosts.Line([]string{"[both.host]:2222"}, rsaPub)+"\n"+
+			knownhosts.Line([]string{"[both.host]:2222"}, newTestHostKey(t))+"\n"), 0600))
+
+	e := New()
+	e.KnownHosts(knownHosts)
+
+	algorithms := e.hostKeyAlgorithms(e.Server("s", "root@host"))
+	require.Equal(t, []string{ssh.KeyAlgoED25519}, algorithms("ed25519.host:22"))
+	require.Equal(t, []string{ssh.KeyAlgoED25519, ssh.SigAlgoRSASHA2512, ssh.SigAlgoRSASHA2256, ssh.KeyAlgoRSA}, algorithms("both.host:2222"))
+	require.Nil(t, algorithms("unknown.host:22"))
+
+	require.Nil(t, e.hostKeyAlgorithms(e.Server("insecure", "root@host").HostKeyPolicy(InsecureHostKeys)))
+	require.Nil(t, e.hostKeyAlgorithms(e.Server("pinned", "root@host").HostKey("SHA256:abc")))
+
+	e.KnownHosts(filepath.Join(dir, "missing"))
+	require.Nil(t, e.hostKeyAlgorithms(e.Server("missing", "root@host"))("ed25519.host:22"))
+}
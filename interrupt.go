@@ -0,0 +1,133 @@
+package exec
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// ErrInterrupted is the error of the commands interrupted by Ctrl-C
+var ErrInterrupted = errors.New("interrupted")
+
+// ErrTimeout is the error of the commands interrupted by their timeout or the timeout of their task
+var ErrTimeout = errors.New("timed out")
+
+// interruptGrace is how long an interrupted remote command has to exit before its session is closed
+var interruptGrace = 5 * time.Second
+
+// handleInterrupts interrupts the running commands on Ctrl-C, so the failure and end hooks can run;
+// it returns a func to stop handling them
+func (e *Exec) handleInterrupts() (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-signals:
+				e.reporter.OnError("local", ErrInterrupted)
+				e.interrupt()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// interrupt cancels the run context, interrupting the running commands and failing the next ones
+func (e *Exec) interrupt() {
+	e.ctxMu.Lock()
+	defer e.ctxMu.Unlock()
+	e.cancel()
+}
+
+// resume replaces an interrupted run context by a new one, for the failure and end hooks to run after Ctrl-C
+func (e *Exec) resume() {
+	e.ctxMu.Lock()
+	defer e.ctxMu.Unlock()
+	if e.ctx.Err() != nil {
+		e.ctx, e.cancel = context.WithCancel(context.Background())
+	}
+}
+
+// runContext returns the context of the run, cancelled by Ctrl-C
+func (e *Exec) runContext() context.Context {
+	e.ctxMu.Lock()
+	defer e.ctxMu.Unlock()
+	return e.ctx
+}
+
+// contextErr returns why ctx is done, ErrInterrupted or ErrTimeout, else err
+func contextErr(ctx context.Context, err error) error {
+	switch ctx.Err() {
+	case context.Canceled:
+		return ErrInterrupted
+	case context.DeadlineExceeded:
+		return ErrTimeout
+	default:
+		return err
+	}
+}
+
+// watch interrupts the command running on s when ctx is done, and closes its session
+// if it is still running after interruptGrace; the returned func stops watching
+func watch(ctx context.Context, s *server) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+		}
+
+		_ = s.sshClient.Signal(os.Interrupt)
+
+		select {
+		case <-done:
+		case <-time.After(interruptGrace):
+			_ = s.sshClient.sess.Close()
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// closeOnDone closes the output pipes of a local command when ctx is done, as the children of the killed command
+// could keep them open; the returned func stops watching
+func closeOnDone(ctx context.Context, pipes ...io.Closer) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			for _, pipe := range pipes {
+				_ = pipe.Close()
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
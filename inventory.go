@@ -0,0 +1,177 @@
+package exec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Inventory is the declarative description of the servers, loaded from a YAML, JSON or TOML file
+// or returned by an InventoryProvider
+type Inventory struct {
+	// Configs are set on the exec
+	Configs map[string]interface{} `yaml:"configs" json:"configs" toml:"configs"`
+	// Servers are declared by name
+	Servers map[string]InventoryServer `yaml:"servers" json:"servers" toml:"servers"`
+	// Groups add their name as role to their servers, and set their configs on them, like stages
+	Groups map[string]InventoryGroup `yaml:"groups" json:"groups" toml:"groups"`
+}
+
+// InventoryServer is a server of an Inventory, its dsn defaulting to its name
+type InventoryServer struct {
+	Dsn     string                 `yaml:"dsn" json:"dsn" toml:"dsn"`
+	Roles   []string               `yaml:"roles" json:"roles" toml:"roles"`
+	Keys    []string               `yaml:"keys" json:"keys" toml:"keys"`
+	Via     string                 `yaml:"via" json:"via" toml:"via"`
+	Configs map[string]interface{} `yaml:"configs" json:"configs" toml:"configs"`
+}
+
+// InventoryGroup is a group of servers of an Inventory
+type InventoryGroup struct {
+	Servers []string               `yaml:"servers" json:"servers" toml:"servers"`
+	Configs map[string]interface{} `yaml:"configs" json:"configs" toml:"configs"`
+}
+
+// LoadInventory declares the servers, roles and configs of a YAML, JSON or TOML inventory file,
+// the format being chosen by its extension; the server configs win over the group configs
+func (e *Exec) LoadInventory(path string) error {
+	data, err := ioutil.ReadFile(expandHome(path))
+	if err != nil {
+		return err
+	}
+
+	inv, err := parseInventory(filepath.Ext(path), data)
+	if err != nil {
+		return fmt.Errorf("inventory %s: %v", path, err)
+	}
+
+	return e.declareInventory(inv)
+}
+
+// parseInventory decodes an inventory in the format of the file extension ext
+func parseInventory(ext string, data []byte) (inv Inventory, err error) {
+	switch strings.ToLower(ext) {
+	case ".yml", ".yaml":
+		err = yaml.UnmarshalStrict(data, &inv)
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		decoder.UseNumber()
+		err = decoder.Decode(&inv)
+	case ".toml":
+		var meta toml.MetaData
+		meta, err = toml.Decode(string(data), &inv)
+		if err == nil && len(meta.Undecoded()) > 0 {
+			err = fmt.Errorf("unknown keys %v", meta.Undecoded())
+		}
+	default:
+		err = fmt.Errorf("unsupported format %q, expected .yml, .yaml, .json or .toml", ext)
+	}
+	return inv, err
+}
+
+// declareInventory declares the servers of inv in e
+func (e *Exec) declareInventory(inv Inventory) error {
+	for name, value := range inv.Configs {
+		e.Set(name, inventoryValue(value))
+	}
+
+	// servers and groups are declared sorted, the first group setting a config on a server wins
+	var names []string
+	for name := range inv.Servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		is := inv.Servers[name]
+		s := e.Server(name, is.Dsn)
+		for _, role := range is.Roles {
+			s.AddRole(role)
+		}
+		for _, key := range is.Keys {
+			s.Key(key)
+		}
+		if is.Via != "" {
+			s.Via(is.Via)
+		}
+		for config, value := range is.Configs {
+			s.Set(config, inventoryValue(value))
+		}
+	}
+
+	var groups []string
+	for group := range inv.Groups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	for _, group := range groups {
+		ig := inv.Groups[group]
+		for _, name := range ig.Servers {
+			s, ok := e.Servers[name]
+			if !ok {
+				return fmt.Errorf("server %s of group %s not found", name, group)
+			}
+			if !s.HasRole(group) {
+				s.AddRole(group)
+			}
+			for config, value := range ig.Configs {
+				if _, ok := s.Configs[config]; !ok {
+					s.Set(config, inventoryValue(value))
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// inventoryValue converts a decoded value to the types read by the config getters:
+// whole numbers to int, lists of strings to []string and maps to map[string]interface{}
+func inventoryValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return int(i)
+		}
+		f, _ := v.Float64()
+		return f
+	case int64:
+		return int(v)
+	case []interface{}:
+		strs := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				values := make([]interface{}, len(v))
+				for i, item := range v {
+					values[i] = inventoryValue(item)
+				}
+				return values
+			}
+			strs = append(strs, s)
+		}
+		return strs
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = inventoryValue(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = inventoryValue(item)
+		}
+		return m
+	default:
+		return value
+	}
+}
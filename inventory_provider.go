@@ -0,0 +1,374 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// InventoryProvider discovers servers when the exec runs, like a cloud API or a Terraform state
+type InventoryProvider interface {
+	// Name identifies the provider, it keys its cache
+	Name() string
+	// Inventory returns the discovered servers, roles and configs
+	Inventory(ctx context.Context) (*Inventory, error)
+}
+
+type inventoryProvider struct {
+	provider InventoryProvider
+	cacheTTL time.Duration
+}
+
+// AddInventoryProvider adds a provider declaring its servers at Run time, before the onStart task;
+// its inventory is cached for cacheTTL (0 disables the cache) unless the --refresh-inventory option is set
+func (e *Exec) AddInventoryProvider(provider InventoryProvider, cacheTTL time.Duration) {
+	e.inventoryProviders = append(e.inventoryProviders, inventoryProvider{provider: provider, cacheTTL: cacheTTL})
+}
+
+// discoverInventory declares the servers of all inventory providers
+func (e *Exec) discoverInventory(refresh bool) error {
+	for _, p := range e.inventoryProviders {
+		inv, err := p.inventory(e.runContext(), e.reporter, refresh)
+		if err != nil {
+			return fmt.Errorf("inventory provider %s: %v", p.provider.Name(), err)
+		}
+		if err := e.declareInventory(*inv); err != nil {
+			return fmt.Errorf("inventory provider %s: %v", p.provider.Name(), err)
+		}
+	}
+	return nil
+}
+
+// inventory returns the cached inventory if still fresh, else the provider's one;
+// a stale cache is used when the provider fails
+func (p inventoryProvider) inventory(ctx context.Context, reporter Reporter, refresh bool) (*Inventory, error) {
+	file := inventoryCacheFile(p.provider.Name())
+
+	if p.cacheTTL > 0 && !refresh {
+		if inv, age, err := readInventoryCache(file); err == nil && age < p.cacheTTL {
+			return inv, nil
+		}
+	}
+
+	inv, err := p.provider.Inventory(ctx)
+	if err != nil {
+		if p.cacheTTL > 0 {
+			if cached, age, cacheErr := readInventoryCache(file); cacheErr == nil {
+				reporter.OnMessage("local", fmt.Sprintf("inventory provider %s: %s, using the cache of %s ago", p.provider.Name(), err, age.Round(time.Second)))
+				return cached, nil
+			}
+		}
+		return nil, err
+	}
+
+	if p.cacheTTL > 0 {
+		if err := writeInventoryCache(file, inv); err != nil {
+			reporter.OnMessage("local", fmt.Sprintf("inventory provider %s: %s", p.provider.Name(), err))
+		}
+	}
+
+	return inv, nil
+}
+
+// inventoryCacheFile returns the cache file of the provider name
+func inventoryCacheFile(name string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "go-exec", "inventory", fmt.Sprintf("%x.json", sha1.Sum([]byte(name))))
+}
+
+func readInventoryCache(file string) (*Inventory, time.Duration, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	inv, err := parseInventory(".json", data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &inv, time.Since(info.ModTime()), nil
+}
+
+func writeInventoryCache(file string, inv *Inventory) error {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0600)
+}
+
+// scriptInventory runs a local command printing a JSON inventory
+type scriptInventory struct {
+	command string
+}
+
+// ScriptInventory returns a provider running the local command, which prints on its stdout
+// either an Inventory in JSON or an Ansible dynamic inventory (groups with hosts, vars and children, and _meta.hostvars)
+func ScriptInventory(command string) InventoryProvider {
+	return scriptInventory{command: command}
+}
+
+func (s scriptInventory) Name() string {
+	return "script " + s.command
+}
+
+func (s scriptInventory) Inventory(ctx context.Context) (*Inventory, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", s.command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return parseScriptInventory(stdout.Bytes())
+}
+
+// parseScriptInventory decodes an Inventory, or an Ansible dynamic inventory when it has other top level keys
+func parseScriptInventory(data []byte) (*Inventory, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return nil, err
+	}
+
+	ansible := false
+	for key := range top {
+		if key != "configs" && key != "servers" && key != "groups" {
+			ansible = true
+		}
+	}
+	if !ansible {
+		inv, err := parseInventory(".json", data)
+		return &inv, err
+	}
+
+	return parseAnsibleInventory(top)
+}
+
+// ansibleGroup is a group of an Ansible dynamic inventory, which can also be a plain list of hosts
+type ansibleGroup struct {
+	Hosts    []string               `json:"hosts"`
+	Vars     map[string]interface{} `json:"vars"`
+	Children []string               `json:"children"`
+}
+
+// parseAnsibleInventory converts an Ansible dynamic inventory: the hosts become servers with their hostvars as configs,
+// the groups become groups, the vars of the all group become the exec configs
+func parseAnsibleInventory(top map[string]json.RawMessage) (*Inventory, error) {
+	var (
+		inv    = &Inventory{Servers: make(map[string]InventoryServer), Groups: make(map[string]InventoryGroup)}
+		groups = make(map[string]ansibleGroup)
+		meta   struct {
+			HostVars map[string]map[string]interface{} `json:"hostvars"`
+		}
+	)
+
+	for name, raw := range top {
+		if name == "_meta" {
+			if err := unmarshalJSON(raw, &meta); err != nil {
+				return nil, fmt.Errorf("_meta: %v", err)
+			}
+			continue
+		}
+
+		var group ansibleGroup
+		if err := unmarshalJSON(raw, &group.Hosts); err != nil {
+			group.Hosts = nil
+			if err := unmarshalJSON(raw, &group); err != nil {
+				return nil, fmt.Errorf("group %s: %v", name, err)
+			}
+		}
+		groups[name] = group
+	}
+
+	hosts := make(map[string]bool)
+	for host := range meta.HostVars {
+		hosts[host] = true
+	}
+	for name, group := range groups {
+		if name == "all" {
+			inv.Configs = group.Vars
+		}
+		for _, host := range group.Hosts {
+			hosts[host] = true
+		}
+	}
+
+	for host := range hosts {
+		inv.Servers[host] = ansibleServer(host, meta.HostVars[host])
+	}
+
+	for name, group := range groups {
+		if name == "all" || name == "ungrouped" {
+			continue
+		}
+		servers := ansibleGroupHosts(groups, name, map[string]bool{})
+		if len(servers) == 0 {
+			continue
+		}
+		inv.Groups[name] = InventoryGroup{Servers: servers, Configs: group.Vars}
+	}
+
+	return inv, nil
+}
+
+// unmarshalJSON decodes data into v, keeping the numbers as json.Number like the inventory files
+func unmarshalJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// ansibleServer converts an Ansible host, reading its connection from the ansible_* vars
+func ansibleServer(host string, vars map[string]interface{}) InventoryServer {
+	s := InventoryServer{Dsn: host, Configs: make(map[string]interface{})}
+
+	var user, port string
+	for name, value := range vars {
+		switch name {
+		case "ansible_host", "ansible_ssh_host":
+			s.Dsn = fmt.Sprint(value)
+		case "ansible_user", "ansible_ssh_user":
+			user = fmt.Sprint(value)
+		case "ansible_port", "ansible_ssh_port":
+			port = fmt.Sprint(value)
+		case "ansible_ssh_private_key_file":
+			s.Keys = append(s.Keys, fmt.Sprint(value))
+		default:
+			s.Configs[name] = value
+		}
+	}
+	if user != "" {
+		s.Dsn = user + "@" + s.Dsn
+	}
+	if port != "" {
+		s.Dsn += ":" + port
+	}
+
+	return s
+}
+
+// ansibleGroupHosts returns the sorted hosts of the group and of its children
+func ansibleGroupHosts(groups map[string]ansibleGroup, name string, visited map[string]bool) []string {
+	if visited[name] {
+		return nil
+	}
+	visited[name] = true
+
+	var hosts []string
+	hosts = append(hosts, groups[name].Hosts...)
+	for _, child := range groups[name].Children {
+		for _, host := range ansibleGroupHosts(groups, child, visited) {
+			if !contains(hosts, host) {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	sort.Strings(hosts)
+
+	return hosts
+}
+
+// terraformInventory reads the instances of a Terraform state file
+type terraformInventory struct {
+	stateFile string
+	user      string
+}
+
+// TerraformInventory returns a provider reading the managed resources with an IP address of a Terraform state file (v4),
+// named after the resource and its index, with the resource name and the comma separated "roles" tag as roles
+func TerraformInventory(stateFile, user string) InventoryProvider {
+	return terraformInventory{stateFile: stateFile, user: user}
+}
+
+func (t terraformInventory) Name() string {
+	return "terraform " + t.stateFile
+}
+
+// terraformAddresses are the attributes holding the address of an instance, by preference
+var terraformAddresses = []string{"public_ip", "ipv4_address", "access_ip_v4", "private_ip", "ip_address"}
+
+func (t terraformInventory) Inventory(ctx context.Context) (*Inventory, error) {
+	data, err := ioutil.ReadFile(expandHome(t.stateFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var state struct {
+		Version   int `json:"version"`
+		Resources []struct {
+			Mode      string `json:"mode"`
+			Name      string `json:"name"`
+			Instances []struct {
+				IndexKey   interface{}            `json:"index_key"`
+				Attributes map[string]interface{} `json:"attributes"`
+			} `json:"instances"`
+		} `json:"resources"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	if state.Version != 4 {
+		return nil, fmt.Errorf("unsupported state version %d, expected 4", state.Version)
+	}
+
+	inv := &Inventory{Servers: make(map[string]InventoryServer)}
+	for _, resource := range state.Resources {
+		if resource.Mode != "managed" {
+			continue
+		}
+		for _, instance := range resource.Instances {
+			var address string
+			for _, attribute := range terraformAddresses {
+				if value, ok := instance.Attributes[attribute].(string); ok && value != "" {
+					address = value
+					break
+				}
+			}
+			if address == "" {
+				continue
+			}
+
+			name := resource.Name
+			if instance.IndexKey != nil {
+				name = fmt.Sprintf("%s.%v", resource.Name, instance.IndexKey)
+			}
+
+			s := InventoryServer{Dsn: address, Roles: []string{resource.Name}}
+			if t.user != "" {
+				s.Dsn = t.user + "@" + address
+			}
+			if tags, ok := instance.Attributes["tags"].(map[string]interface{}); ok {
+				if roles, ok := tags["roles"].(string); ok {
+					for _, role := range strings.Split(roles, ",") {
+						if role = strings.TrimSpace(role); role != "" && !contains(s.Roles, role) {
+							s.Roles = append(s.Roles, role)
+						}
+					}
+				}
+			}
+			inv.Servers[name] = s
+		}
+	}
+
+	return inv, nil
+}
@@ -0,0 +1,181 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testProvider returns its inventory, or its error, counting its calls
+type testProvider struct {
+	inv   *Inventory
+	err   error
+	calls int
+}
+
+func (p *testProvider) Name() string {
+	return "test"
+}
+
+func (p *testProvider) Inventory(ctx context.Context) (*Inventory, error) {
+	p.calls++
+	return p.inv, p.err
+}
+
+// newTestCache sets the user cache dir to a temp dir
+func newTestCache(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "exec-cache")
+	require.NoError(t, err)
+
+	cache := os.Getenv("XDG_CACHE_HOME")
+	_ = os.Setenv("XDG_CACHE_HOME", dir)
+
+	return func() {
+		_ = os.Setenv("XDG_CACHE_HOME", cache)
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestExec_AddInventoryProvider(t *testing.T) {
+	defer newTestCache(t)()
+
+	p := &testProvider{inv: &Inventory{
+		Servers: map[string]InventoryServer{"prod1": {Dsn: "root@10.0.0.1", Roles: []string{"web"}, Configs: map[string]interface{}{"workers": 4}}},
+	}}
+
+	e := New()
+	e.AddInventoryProvider(p, time.Hour)
+	require.NoError(t, e.discoverInventory(false))
+	require.Equal(t, 1, p.calls)
+	require.Equal(t, "root@10.0.0.1", e.Servers["prod1"].Dsn)
+	require.True(t, e.Servers["prod1"].HasRole("web"))
+
+	// the cache is used while fresh, then refreshed on demand
+	e = New()
+	e.AddInventoryProvider(p, time.Hour)
+	require.NoError(t, e.discoverInventory(false))
+	require.Equal(t, 1, p.calls)
+	require.Equal(t, 4, e.Servers["prod1"].Configs["workers"].Int())
+
+	require.NoError(t, e.discoverInventory(true))
+	require.Equal(t, 2, p.calls)
+
+	// a stale cache is used when the provider fails
+	p.err = errors.New("unreachable")
+	e = New()
+	e.AddInventoryProvider(p, time.Nanosecond)
+	require.NoError(t, e.discoverInventory(false))
+	require.Equal(t, 3, p.calls)
+	require.Contains(t, e.Servers, "prod1")
+
+	// without cache the error is returned
+	e = New()
+	e.AddInventoryProvider(p, 0)
+	require.EqualError(t, e.discoverInventory(false), "inventory provider test: unreachable")
+}
+
+func TestScriptInventory(t *testing.T) {
+	testCases := []struct {
+		name    string
+		command string
+		servers map[string]string
+		groups  map[string][]string
+		err     string
+	}{
+		{
+			name:    "inventory",
+			command: `echo '{"servers": {"prod1": {"dsn": "root@10.0.0.1"}}}'`,
+			servers: map[string]string{"prod1": "root@10.0.0.1"},
+		},
+		{
+			name: "ansible",
+			command: `cat <<'EOF'
+{
+  "all": {"vars": {"app": "shop"}},
+  "web": {"hosts": ["web1", "web2"], "vars": {"workers": 4}},
+  "db": ["db1"],
+  "prod": {"children": ["web", "db"]},
+  "_meta": {"hostvars": {
+    "web1": {"ansible_host": "10.0.0.1", "ansible_user": "deploy", "ansible_port": 2222},
+    "db1": {"ansible_host": "10.0.0.3", "ansible_ssh_private_key_file": "~/.ssh/db"}
+  }}
+}
+EOF`,
+			servers: map[string]string{"web1": "deploy@10.0.0.1:2222", "web2": "web2", "db1": "10.0.0.3"},
+			groups:  map[string][]string{"web": {"web1", "web2"}, "db": {"db1"}, "prod": {"db1", "web1", "web2"}},
+		},
+		{
+			name:    "failing script",
+			command: `echo failed >&2; exit 1`,
+			err:     "exit status 1: failed",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			inv, err := ScriptInventory(tc.command).Inventory(context.Background())
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+
+			servers := make(map[string]string)
+			for name, s := range inv.Servers {
+				servers[name] = s.Dsn
+			}
+			require.Equal(t, tc.servers, servers)
+
+			for name, servers := range tc.groups {
+				require.Equal(t, servers, inv.Groups[name].Servers)
+			}
+		})
+	}
+}
+
+func TestScriptInventory_ansibleConfigs(t *testing.T) {
+	inv, err := ScriptInventory(`echo '{"all": {"vars": {"app": "shop"}}, "web": {"hosts": ["web1"], "vars": {"workers": 4}}}'`).Inventory(context.Background())
+	require.NoError(t, err)
+
+	e := New()
+	require.NoError(t, e.declareInventory(*inv))
+	require.Equal(t, "shop", e.Get("app").String())
+	require.Equal(t, 4, e.Servers["web1"].Configs["workers"].Int())
+	require.True(t, e.Servers["web1"].HasRole("web"))
+}
+
+func TestTerraformInventory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exec-terraform")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	state := `{
+  "version": 4,
+  "resources": [
+    {"mode": "data", "type": "aws_ami", "name": "ubuntu", "instances": [{"attributes": {"public_ip": "1.1.1.1"}}]},
+    {"mode": "managed", "type": "aws_instance", "name": "web", "instances": [
+      {"index_key": 0, "attributes": {"public_ip": "1.2.3.4", "tags": {"roles": "app, prod"}}},
+      {"index_key": 1, "attributes": {"public_ip": "", "private_ip": "10.0.0.2"}}
+    ]},
+    {"mode": "managed", "type": "aws_security_group", "name": "ssh", "instances": [{"attributes": {"id": "sg-1"}}]}
+  ]
+}`
+	file := filepath.Join(dir, "terraform.tfstate")
+	require.NoError(t, ioutil.WriteFile(file, []byte(state), 0600))
+
+	inv, err := TerraformInventory(file, "ubuntu").Inventory(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, map[string]InventoryServer{
+		"web.0": {Dsn: "ubuntu@1.2.3.4", Roles: []string{"web", "app", "prod"}},
+		"web.1": {Dsn: "ubuntu@10.0.0.2", Roles: []string{"web"}},
+	}, inv.Servers)
+
+	require.NoError(t, ioutil.WriteFile(file, []byte(`{"version": 3}`), 0600))
+	_, err = TerraformInventory(file, "").Inventory(context.Background())
+	require.EqualError(t, err, "unsupported state version 3, expected 4")
+}
@@ -0,0 +1,169 @@
+package exec
+
+import (
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testInventoryYAML = `
+configs:
+  app: shop
+servers:
+  bastion:
+    dsn: root@bastion.domain.com
+  prod1:
+    dsn: root@10.0.0.1
+    roles: [web]
+    via: bastion
+    configs:
+      bin/mysql: mysql prod1
+      workers: 4
+  prod2:
+    dsn: root@10.0.0.2
+    roles: [web, db]
+groups:
+  prod:
+    servers: [prod1, prod2]
+    configs:
+      bin/mysql: mysql prod
+      branches: [master, release]
+`
+
+const testInventoryJSON = `{
+  "configs": {"app": "shop"},
+  "servers": {
+    "bastion": {"dsn": "root@bastion.domain.com"},
+    "prod1": {
+      "dsn": "root@10.0.0.1",
+      "roles": ["web"],
+      "via": "bastion",
+      "configs": {"bin/mysql": "mysql prod1", "workers": 4}
+    },
+    "prod2": {"dsn": "root@10.0.0.2", "roles": ["web", "db"]}
+  },
+  "groups": {
+    "prod": {
+      "servers": ["prod1", "prod2"],
+      "configs": {"bin/mysql": "mysql prod", "branches": ["master", "release"]}
+    }
+  }
+}`
+
+const testInventoryTOML = `
+[configs]
+app = "shop"
+
+[servers.bastion]
+dsn = "root@bastion.domain.com"
+
+[servers.prod1]
+dsn = "root@10.0.0.1"
+roles = ["web"]
+via = "bastion"
+  [servers.prod1.configs]
+  "bin/mysql" = "mysql prod1"
+  workers = 4
+
+[servers.prod2]
+dsn = "root@10.0.0.2"
+roles = ["web", "db"]
+
+[groups.prod]
+servers = ["prod1", "prod2"]
+  [groups.prod.configs]
+  "bin/mysql" = "mysql prod"
+  branches = ["master", "release"]
+`
+
+func TestExec_LoadInventory(t *testing.T) {
+	dir, cleanup := newTestHome(t, nil)
+	defer cleanup()
+
+	testCases := []struct {
+		file string
+		data string
+	}{
+		{file: "inventory.yml", data: testInventoryYAML},
+		{file: "inventory.json", data: testInventoryJSON},
+		{file: "inventory.toml", data: testInventoryTOML},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.file, func(t *testing.T) {
+			path := filepath.Join(dir, tc.file)
+			require.NoError(t, ioutil.WriteFile(path, []byte(tc.data), 0600))
+
+			e := New()
+			require.NoError(t, e.LoadInventory(path))
+
+			require.Len(t, e.Servers, 3)
+			require.Equal(t, "shop", e.Get("app").String())
+
+			prod1 := e.Servers["prod1"]
+			require.Equal(t, "root@10.0.0.1", prod1.Dsn)
+			require.Equal(t, "bastion", prod1.via)
+			require.Equal(t, []string{"web", "prod"}, prod1.roles)
+			require.Equal(t, "mysql prod1", prod1.Configs["bin/mysql"].String())
+			require.Equal(t, 4, prod1.Configs["workers"].Int())
+			require.Equal(t, []string{"master", "release"}, prod1.Configs["branches"].Slice())
+
+			prod2 := e.Servers["prod2"]
+			require.Equal(t, []string{"web", "db", "prod"}, prod2.roles)
+			require.Equal(t, "mysql prod", prod2.Configs["bin/mysql"].String())
+		})
+	}
+}
+
+func TestExec_LoadInventoryErrors(t *testing.T) {
+	dir, cleanup := newTestHome(t, nil)
+	defer cleanup()
+
+	testCases := []struct {
+		file string
+		data string
+		err  string
+	}{
+		{
+			file: "inventory.ini",
+			data: "",
+			err:  `unsupported format ".ini"`,
+		},
+		{
+			file: "unknown.yml",
+			data: "servers:\n  prod1:\n    host: root@10.0.0.1\n",
+			err:  "field host not found",
+		},
+		{
+			file: "unknown.json",
+			data: `{"servers": {"prod1": {"host": "root@10.0.0.1"}}}`,
+			err:  `unknown field "host"`,
+		},
+		{
+			file: "unknown.toml",
+			data: "[servers.prod1]\nhost = \"root@10.0.0.1\"\n",
+			err:  "unknown keys [servers.prod1.host]",
+		},
+		{
+			file: "group.yml",
+			data: "groups:\n  prod:\n    servers: [prod1]\n",
+			err:  "server prod1 of group prod not found",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.file, func(t *testing.T) {
+			path := filepath.Join(dir, tc.file)
+			require.NoError(t, ioutil.WriteFile(path, []byte(tc.data), 0600))
+
+			err := New().LoadInventory(path)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.err)
+		})
+	}
+
+	err := New().LoadInventory(filepath.Join(dir, "missing.yml"))
+	require.True(t, os.IsNotExist(err))
+}
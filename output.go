@@ -1,19 +1,51 @@
 package exec
 
 import (
+	"golang.org/x/crypto/ssh"
+	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Output struct {
-	text string
-	err  error
+	text     string
+	stdout   string
+	stderr   string
+	exitCode int
+	duration time.Duration
+	err      error
 }
 
 func (o Output) HasError() bool {
 	return o.err != nil
 }
 
+// Err returns the error of the command, an exit code different than 0 included
+func (o Output) Err() error {
+	return o.err
+}
+
+// ExitCode returns the exit code of the command, -1 if the command did not exit properly
+func (o Output) ExitCode() int {
+	return o.exitCode
+}
+
+// Stdout returns the untrimmed standard output of the command
+func (o Output) Stdout() string {
+	return o.stdout
+}
+
+// Stderr returns the untrimmed standard error of the command
+func (o Output) Stderr() string {
+	return o.stderr
+}
+
+// Duration returns how long the command ran
+func (o Output) Duration() time.Duration {
+	return o.duration
+}
+
 func (o Output) String() string {
 	return o.text
 }
@@ -33,3 +65,17 @@ func (o Output) Bool() bool {
 func (o Output) Slice(sep string) []string {
 	return strings.Split(o.text, sep)
 }
+
+// exitCode returns the exit code carried by a command error, 0 for no error and -1 if unknown
+func exitCode(err error) int {
+	switch err := err.(type) {
+	case nil:
+		return 0
+	case *exec.ExitError:
+		return err.ExitCode()
+	case *ssh.ExitError:
+		return err.ExitStatus()
+	default:
+		return -1
+	}
+}
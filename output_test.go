@@ -3,7 +3,9 @@ package exec
 import (
 	"errors"
 	"github.com/stretchr/testify/require"
+	"os/exec"
 	"testing"
+	"time"
 )
 
 func TestOutput_HasError(t *testing.T) {
@@ -115,3 +117,29 @@ func TestOutput_Slice(t *testing.T) {
 		})
 	}
 }
+
+func TestOutput_Accessors(t *testing.T) {
+	err := errors.New("exit status 1")
+	o := Output{
+		text:     "out",
+		stdout:   "out\n",
+		stderr:   "err\n",
+		exitCode: 1,
+		duration: time.Second,
+		err:      err,
+	}
+
+	require.Equal(t, "out\n", o.Stdout())
+	require.Equal(t, "err\n", o.Stderr())
+	require.Equal(t, 1, o.ExitCode())
+	require.Equal(t, time.Second, o.Duration())
+	require.Equal(t, err, o.Err())
+}
+
+func Test_exitCode(t *testing.T) {
+	exitErr := exec.Command("/bin/sh", "-c", "exit 2").Run()
+
+	require.Equal(t, 0, exitCode(nil))
+	require.Equal(t, 2, exitCode(exitErr))
+	require.Equal(t, -1, exitCode(errors.New("connection refused")))
+}
@@ -0,0 +1,203 @@
+package exec
+
+import (
+	"fmt"
+	"github.com/fatih/color"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stream is the output stream of a command
+type Stream int
+
+const (
+	// Stdout is the standard output of a command
+	Stdout Stream = iota + 1
+	// Stderr is the standard error of a command
+	Stderr
+)
+
+func (s Stream) String() string {
+	if s == Stderr {
+		return "stderr"
+	}
+	return "stdout"
+}
+
+// Reporter displays the progress of a run, it can be called from several goroutines when tasks run in parallel;
+// the server is "local" for the local commands, and empty for the messages and tasks not bound to a server
+type Reporter interface {
+	// OnTaskStart is called when a task starts, once per server
+	OnTaskStart(task, server string)
+	// OnCommand is called when a command or a transfer starts
+	OnCommand(server, command string)
+	// OnOutputChunk is called with each line of the output of a command, as it is read
+	OnOutputChunk(server string, stream Stream, chunk []byte)
+	// OnError is called when a command, a transfer or a helper fails
+	OnError(server string, err error)
+	// OnTaskEnd is called when a task ends, once per server
+	OnTaskEnd(task, server string, duration time.Duration, err error)
+	// OnMessage is called with the other information, like the texts of Println
+	OnMessage(server, message string)
+}
+
+// Reporter sets the reporter displaying the progress of the run, by default NewColorReporter(color.Output)
+func (e *Exec) Reporter(reporter Reporter) {
+	e.reporter = reporter
+}
+
+type reportLevel int
+
+const (
+	quietLevel reportLevel = iota + 1
+	normalLevel
+	verboseLevel
+)
+
+// textReporter writes the progress as text, colored or not, each output line being prefixed with its server
+type textReporter struct {
+	mu          sync.Mutex
+	w           io.Writer
+	plain       bool
+	level       reportLevel
+	timestamps  bool
+	streamNames bool
+}
+
+// ReporterOption configures a text reporter
+type ReporterOption func(r *textReporter)
+
+// WithTimestamps prefixes each line with its time
+func WithTimestamps() ReporterOption {
+	return func(r *textReporter) {
+		r.timestamps = true
+	}
+}
+
+// WithStreamNames prefixes each output line with its stream name, stdout or stderr
+func WithStreamNames() ReporterOption {
+	return func(r *textReporter) {
+		r.streamNames = true
+	}
+}
+
+func newTextReporter(w io.Writer, plain bool, level reportLevel, options []ReporterOption) *textReporter {
+	r := &textReporter{w: w, plain: plain, level: level, timestamps: level == verboseLevel}
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+// NewColorReporter returns the default reporter, writing colored text to w
+func NewColorReporter(w io.Writer, options ...ReporterOption) Reporter {
+	return newTextReporter(w, false, normalLevel, options)
+}
+
+// NewPlainReporter returns a reporter writing text without colors to w, like for CI logs or tests
+func NewPlainReporter(w io.Writer, options ...ReporterOption) Reporter {
+	return newTextReporter(w, true, normalLevel, options)
+}
+
+// NewQuietReporter returns a reporter writing only the errors to w
+func NewQuietReporter(w io.Writer, options ...ReporterOption) Reporter {
+	return newTextReporter(w, false, quietLevel, options)
+}
+
+// NewVerboseReporter returns a reporter writing colored text to w, with the time of each line,
+// and the duration and result of each task
+func NewVerboseReporter(w io.Writer, options ...ReporterOption) Reporter {
+	return newTextReporter(w, false, verboseLevel, options)
+}
+
+func (r *textReporter) OnTaskStart(task, server string) {
+	if r.level < normalLevel {
+		return
+	}
+	if server == "" {
+		r.println(r.paint(color.FgWhite, "➤ Executing task ") + r.paint(color.FgYellow, "%s", task))
+	} else {
+		r.println(r.paint(color.FgWhite, "➤ Executing task ") + r.paint(color.FgYellow, "%s", task) + r.paint(color.FgWhite, " on server ") + r.paint(color.FgGreen, "[%s]", server))
+	}
+}
+
+func (r *textReporter) OnCommand(server, command string) {
+	if r.level < normalLevel {
+		return
+	}
+	r.println(r.paint(color.FgGreen, "[%s] > ", server) + r.paint(color.FgWhite, "`%s`", command))
+}
+
+func (r *textReporter) OnOutputChunk(server string, stream Stream, chunk []byte) {
+	if r.level < normalLevel {
+		return
+	}
+
+	prefix := "[" + server + "]"
+	if r.streamNames {
+		prefix += " " + stream.String()
+	}
+	if stream == Stderr {
+		prefix = r.paint(color.FgRed, "%s", prefix)
+	} else {
+		prefix = r.paint(color.FgGreen, "%s", prefix)
+	}
+
+	for _, line := range strings.SplitAfter(string(chunk), "\n") {
+		if line != "" {
+			r.println(prefix + " " + strings.TrimSuffix(line, "\n"))
+		}
+	}
+}
+
+func (r *textReporter) OnError(server string, err error) {
+	r.println(r.paint(color.FgRed, "[%s] < %q", server, err))
+}
+
+func (r *textReporter) OnTaskEnd(task, server string, duration time.Duration, err error) {
+	if r.level < verboseLevel {
+		return
+	}
+	on := ""
+	if server != "" {
+		on = fmt.Sprintf(" on server [%s]", server)
+	}
+	if err != nil {
+		r.println(r.paint(color.FgRed, "✘ Task %s%s failed in %s: %s", task, on, duration, err))
+	} else {
+		r.println(r.paint(color.FgWhite, "✔ Task %s%s finished in %s", task, on, duration))
+	}
+}
+
+func (r *textReporter) OnMessage(server, message string) {
+	if r.level < normalLevel {
+		return
+	}
+	if server == "" {
+		r.println(r.paint(color.FgWhite, "%s", message))
+	} else {
+		r.println(r.paint(color.FgYellow, "[%s] > %s", server, message))
+	}
+}
+
+// paint formats the text in the color, unless plain
+func (r *textReporter) paint(attribute color.Attribute, format string, args ...interface{}) string {
+	if r.plain {
+		return fmt.Sprintf(format, args...)
+	}
+	return color.New(attribute).Sprintf(format, args...)
+}
+
+// println writes a line, prefixed by its time if requested, the next lines of a multi-line text being indented
+func (r *textReporter) println(line string) {
+	if r.timestamps {
+		line = time.Now().Format("15:04:05 ") + strings.Replace(line, "\n", "\n         ", -1)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, _ = io.WriteString(r.w, line+"\n")
+}
@@ -0,0 +1,144 @@
+package exec
+
+import (
+	"bytes"
+	"errors"
+	"github.com/fatih/color"
+	"github.com/stretchr/testify/require"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExec_Reporter(t *testing.T) {
+	var buf bytes.Buffer
+
+	e := New()
+	e.Reporter(NewPlainReporter(&buf))
+	tk := e.TaskCtx("task", func(c *Ctx) {
+		c.Local("echo out")
+		c.Local("echo err >&2")
+		c.Local("printf 'a\\nb'")
+		c.Println("done")
+		c.Local("exit 2")
+	})
+	require.NoError(t, tk.run())
+
+	require.Equal(t, strings.Join([]string{
+		"➤ Executing task task",
+		"[local] > `echo out`",
+		"[local] out",
+		"[local] > `echo err >&2`",
+		"[local] err",
+		"[local] > `printf 'a\\nb'`",
+		"[local] a",
+		"[local] b",
+		"done",
+		"[local] > `exit 2`",
+		`[local] < "exit status 2"`,
+		"",
+	}, "\n"), buf.String())
+}
+
+func TestExec_ReporterPrompts(t *testing.T) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() {
+		os.Stdin = stdin
+		_ = r.Close()
+		_ = w.Close()
+	}()
+	answer := func(line string) {
+		_, err := w.Write([]byte(line + "\n"))
+		require.NoError(t, err)
+	}
+
+	var buf bytes.Buffer
+	e := New()
+	e.Reporter(NewPlainReporter(&buf))
+
+	answer("answer")
+	require.Equal(t, "answer", e.Ask("Name?"))
+	answer("")
+	require.Equal(t, "default", e.Ask("Dir?", "default"))
+	answer("y")
+	require.True(t, e.AskWithConfirmation("Deploy?"))
+
+	require.Equal(t, strings.Join([]string{
+		"[local] > Name?",
+		"[local] > Dir? [default: default]",
+		"[local] > Deploy?",
+		"",
+	}, "\n"), buf.String())
+}
+
+func TestTextReporter(t *testing.T) {
+	err := errors.New("failed")
+
+	testCases := []struct {
+		name     string
+		reporter func(buf *bytes.Buffer) Reporter
+		expected string
+	}{
+		{
+			name:     "plain",
+			reporter: func(buf *bytes.Buffer) Reporter { return NewPlainReporter(buf) },
+			expected: "➤ Executing task deploy on server [prod1]\n[prod1] > `hostname`\n[prod1] prod1\n[prod1] err\n[prod1] < \"failed\"\n[prod1] > connected\n",
+		},
+		{
+			name:     "stream names and timestamps",
+			reporter: func(buf *bytes.Buffer) Reporter { return NewPlainReporter(buf, WithStreamNames(), WithTimestamps()) },
+			expected: "00:00:00 ➤ Executing task deploy on server [prod1]\n00:00:00 [prod1] > `hostname`\n00:00:00 [prod1] stdout prod1\n00:00:00 [prod1] stderr err\n00:00:00 [prod1] < \"failed\"\n00:00:00 [prod1] > connected\n",
+		},
+		{
+			name:     "quiet",
+			reporter: func(buf *bytes.Buffer) Reporter { return NewQuietReporter(buf) },
+			expected: "[prod1] < \"failed\"\n",
+		},
+		{
+			name:     "verbose",
+			reporter: func(buf *bytes.Buffer) Reporter { return NewVerboseReporter(buf) },
+			expected: "00:00:00 ➤ Executing task deploy on server [prod1]\n00:00:00 [prod1] > `hostname`\n00:00:00 [prod1] prod1\n00:00:00 [prod1] err\n00:00:00 [prod1] < \"failed\"\n00:00:00 ✘ Task deploy on server [prod1] failed in 1s: failed\n00:00:00 [prod1] > connected\n",
+		},
+	}
+
+	noColor := color.NoColor
+	color.NoColor = true
+	defer func() { color.NoColor = noColor }()
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			r := tc.reporter(&buf)
+
+			r.OnTaskStart("deploy", "prod1")
+			r.OnCommand("prod1", "hostname")
+			r.OnOutputChunk("prod1", Stdout, []byte("prod1\n"))
+			r.OnOutputChunk("prod1", Stderr, []byte("err\n"))
+			r.OnError("prod1", err)
+			r.OnTaskEnd("deploy", "prod1", time.Second, err)
+			r.OnMessage("prod1", "connected")
+
+			output := regexp.MustCompile(`(?m)^\d\d:\d\d:\d\d `).ReplaceAllString(buf.String(), "00:00:00 ")
+			require.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestColorReporter(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = false
+	defer func() { color.NoColor = noColor }()
+
+	var buf bytes.Buffer
+	NewColorReporter(&buf).OnError("prod1", errors.New("failed"))
+	require.Equal(t, color.RedString(`[prod1] < "failed"`)+"\n", buf.String())
+
+	buf.Reset()
+	NewPlainReporter(&buf).OnError("prod1", errors.New("failed"))
+	require.Equal(t, `[prod1] < "failed"`+"\n", buf.String())
+}
@@ -0,0 +1,74 @@
+package exec
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// Backoff returns how long to wait after the failed attempt n, starting at 1, before the next one
+type Backoff func(n int) time.Duration
+
+// ConstantBackoff waits d between attempts
+func ConstantBackoff(d time.Duration) Backoff {
+	return func(int) time.Duration {
+		return d
+	}
+}
+
+// ExponentialBackoff waits initial after the first failed attempt, then doubles the wait after each next one, up to max
+func ExponentialBackoff(initial, max time.Duration) Backoff {
+	return func(n int) time.Duration {
+		d := initial
+		for i := 1; i < n && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+// retryPolicy is how many times a command or a connection is attempted, and how long to wait between attempts
+type retryPolicy struct {
+	attempts int
+	backoff  Backoff
+}
+
+// retries checks if the failed attempt n should be followed by another one
+func (p retryPolicy) retries(n int) bool {
+	return n < p.attempts
+}
+
+// delay returns how long to wait after the failed attempt n
+func (p retryPolicy) delay(n int) time.Duration {
+	if p.backoff == nil {
+		return 0
+	}
+	return p.backoff(n)
+}
+
+// Retry attempts the command up to attempts times while it fails, waiting backoff between attempts;
+// it overrides the retries of the task
+func Retry(attempts int, backoff Backoff) CommandOption {
+	return func(o *commandOptions) {
+		o.retry = &retryPolicy{attempts: attempts, backoff: backoff}
+	}
+}
+
+// ConnectRetry sets how many times the connection to a server is attempted while it is unreachable,
+// like during a reboot, waiting backoff between attempts; by default 3 times, from 1s to 10s
+func (e *Exec) ConnectRetry(attempts int, backoff Backoff) {
+	e.connectRetry = retryPolicy{attempts: attempts, backoff: backoff}
+}
+
+// retryableConnectErr checks if a connection failed because the server was unreachable or closed it,
+// and not because of the authentication or the host key
+func retryableConnectErr(err error) bool {
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	reason := err.Error()
+	return strings.HasSuffix(reason, "EOF") || strings.Contains(reason, "connection reset by peer")
+}
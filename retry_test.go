@@ -0,0 +1,200 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	testCases := []struct {
+		name     string
+		backoff  Backoff
+		expected []time.Duration
+	}{
+		{
+			name:     "constant",
+			backoff:  ConstantBackoff(time.Second),
+			expected: []time.Duration{time.Second, time.Second, time.Second},
+		},
+		{
+			name:     "exponential",
+			backoff:  ExponentialBackoff(time.Second, 5*time.Second),
+			expected: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for i, expected := range tc.expected {
+				require.Equal(t, expected, tc.backoff(i+1), "attempt %d", i+1)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	p := retryPolicy{attempts: 2}
+	require.True(t, p.retries(1))
+	require.False(t, p.retries(2))
+	require.Equal(t, time.Duration(0), p.delay(1))
+
+	require.False(t, retryPolicy{}.retries(1))
+}
+
+func TestRetryableConnectErr(t *testing.T) {
+	testCases := []struct {
+		err       error
+		retryable bool
+	}{
+		{err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, retryable: true},
+		{err: errors.New("ssh: handshake failed: EOF"), retryable: true},
+		{err: errors.New("ssh: handshake failed: read tcp: connection reset by peer"), retryable: true},
+		{err: errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none], no supported methods remain"), retryable: false},
+		{err: errors.New("ssh: handshake failed: knownhosts: key mismatch"), retryable: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.err.Error(), func(t *testing.T) {
+			require.Equal(t, tc.retryable, retryableConnectErr(tc.err))
+		})
+	}
+}
+
+func TestSshClient_ConnectWithRetry(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+
+	testCases := []struct {
+		name        string
+		maxAttempts int
+		errs        []error
+		attempts    int
+		retried     []string
+		err         string
+	}{
+		{
+			name:        "reconnects while refused",
+			maxAttempts: 3,
+			errs:        []error{refused, refused, nil},
+			attempts:    3,
+			retried:     []string{"1 1ms", "2 1ms"},
+		},
+		{
+			name:        "gives up after the attempts",
+			maxAttempts: 2,
+			errs:        []error{refused, refused, refused},
+			attempts:    2,
+			retried:     []string{"1 1ms"},
+			err:         `Connect("root@domain.com:22"): dial tcp: connection refused`,
+		},
+		{
+			name:        "does not retry the authentication",
+			maxAttempts: 3,
+			errs:        []error{errors.New("ssh: handshake failed: ssh: unable to authenticate"), nil},
+			attempts:    1,
+			err:         `Connect("root@domain.com:22"): ssh: handshake failed: ssh: unable to authenticate (tried: )`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newTestSSHServer(t)
+			defer server.close()
+
+			var (
+				attempts int
+				retried  []string
+			)
+			c := &sshClient{
+				hostKeyCallback: ssh.InsecureIgnoreHostKey(),
+				connectRetry:    retryPolicy{attempts: tc.maxAttempts, backoff: ConstantBackoff(time.Millisecond)},
+				onConnectRetry: func(attempt int, delay time.Duration, err error) {
+					retried = append(retried, fmt.Sprintf("%d %s", attempt, delay))
+				},
+			}
+			err := c.ConnectWith(context.Background(), "root@domain.com", func(net, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+				err := tc.errs[attempts]
+				attempts++
+				if err != nil {
+					return nil, err
+				}
+				return ssh.Dial(net, server.addr(), config)
+			})
+
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+			} else {
+				require.NoError(t, err)
+				require.True(t, c.connected())
+			}
+			require.Equal(t, tc.attempts, attempts)
+			require.Equal(t, tc.retried, retried)
+		})
+	}
+}
+
+func TestSshClient_ConnectWithCancelled(t *testing.T) {
+	unreachable := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+
+	t.Run("stops waiting between attempts", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		attempts := 0
+		c := &sshClient{
+			hostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			connectRetry:    retryPolicy{attempts: 3, backoff: ConstantBackoff(time.Hour)},
+			onConnectRetry: func(int, time.Duration, error) {
+				cancel()
+			},
+		}
+		err := c.ConnectWith(ctx, "root@domain.com", func(string, string, *ssh.ClientConfig) (*ssh.Client, error) {
+			attempts++
+			return nil, unreachable
+		})
+
+		require.Equal(t, ErrInterrupted, err)
+		require.Equal(t, 1, attempts)
+		require.False(t, c.connected())
+	})
+
+	t.Run("stops dialing", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		hanging := make(chan struct{})
+		defer close(hanging)
+
+		timeout := make(chan time.Duration, 1)
+		c := &sshClient{hostKeyCallback: ssh.InsecureIgnoreHostKey()}
+		err := c.ConnectWith(ctx, "root@domain.com", func(_, _ string, config *ssh.ClientConfig) (*ssh.Client, error) {
+			timeout <- config.Timeout
+			<-hanging
+			return nil, unreachable
+		})
+
+		require.Equal(t, ErrTimeout, err)
+		require.Equal(t, dialTimeout, <-timeout)
+		require.False(t, c.connected())
+	})
+
+	t.Run("stops waiting for the connection in progress", func(t *testing.T) {
+		c := &sshClient{}
+		connect, done, err := c.startConnecting(context.Background())
+		require.NoError(t, err)
+		require.True(t, connect)
+		defer done()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		connect, _, err = c.startConnecting(ctx)
+		require.Equal(t, ErrInterrupted, err)
+		require.False(t, connect)
+	})
+}
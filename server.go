@@ -1,15 +1,24 @@
 package exec
 
-import "strings"
-
 type server struct {
 	Name    string
 	Dsn     string
 	Configs map[string]*config
 
-	key       *string
-	roles     []string
-	sshClient *sshClient
+	key           *string
+	roles         []string
+	sshClient     *sshClient
+	hostKeys      []string
+	hostKeyPolicy hostKeyPolicy
+	knownHosts    []string
+	via           string
+	env           map[string]string
+
+	password            *string
+	passphrase          passphraseFunc
+	certificates        []string
+	keyboardInteractive keyboardInteractiveFunc
+	sudoPassword        *string
 }
 
 func (s *server) AddRole(role string) *server {
@@ -37,13 +46,76 @@ func (s *server) Key(file string) *server {
 	return s
 }
 
+// Password sets the password of the server's user, tried after the keys,
+// also answering the keyboard-interactive questions unless KeyboardInteractive is set
+func (s *server) Password(password string) *server {
+	s.password = &password
+	return s
+}
+
+// Passphrase sets the func returning the passphrase of the server's encrypted private keys, overriding the exec's one
+func (s *server) Passphrase(f func(file string) (string, error)) *server {
+	s.passphrase = f
+	return s
+}
+
+// Certificate adds an OpenSSH certificate file authenticating with its private key,
+// the <key>-cert.pub files next to the keys are added automatically
+func (s *server) Certificate(file string) *server {
+	s.certificates = append(s.certificates, file)
+	return s
+}
+
+// KeyboardInteractive sets the func answering the keyboard-interactive authentication questions, like OTP codes
+func (s *server) KeyboardInteractive(f func(user, instruction string, questions []string, echos []bool) ([]string, error)) *server {
+	s.keyboardInteractive = f
+	return s
+}
+
+// SudoPassword sets the password answering the sudo prompt of the commands run as another user,
+// sent over their stdin and masked in their output
+func (s *server) SudoPassword(password string) *server {
+	s.sudoPassword = &password
+	return s
+}
+
+// Env sets env vars for the commands run on the server, overriding the exec's ones
+func (s *server) Env(env map[string]string) *server {
+	s.env = mergeEnv(s.env, env)
+	return s
+}
+
+// HostKey pins the accepted SSH host keys of the server, as SHA256 fingerprints or authorized_keys lines
+func (s *server) HostKey(keys ...string) *server {
+	s.hostKeys = append(s.hostKeys, keys...)
+	return s
+}
+
+// HostKeyPolicy sets how the SSH host key of the server is verified, overriding the exec's policy
+func (s *server) HostKeyPolicy(policy hostKeyPolicy) *server {
+	s.hostKeyPolicy = policy
+	return s
+}
+
+// KnownHosts sets the known_hosts files used to verify the SSH host key of the server, overriding the exec's ones
+func (s *server) KnownHosts(files ...string) *server {
+	s.knownHosts = files
+	return s
+}
+
+// Via sets the server, declared in exec, to connect through as a jump host;
+// the jump server can itself be connected via another one
+func (s *server) Via(server string) *server {
+	s.via = server
+	return s
+}
+
 func (s *server) GetUser() string {
-	return s.Dsn[:strings.Index(s.Dsn, "@")]
+	user, _, _ := splitDsn(s.Dsn)
+	return user
 }
 
 func (s *server) GetHost() string {
-	if strings.Index(s.Dsn, ":") > 0 {
-		return s.Dsn[strings.Index(s.Dsn, "@")+1 : strings.Index(s.Dsn, ":")]
-	}
-	return s.Dsn[strings.Index(s.Dsn, "@")+1:]
+	_, host, _ := splitDsn(s.Dsn)
+	return host
 }
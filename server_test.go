@@ -57,6 +57,9 @@ func TestServer_GetUser(t *testing.T) {
 	}
 
 	require.Equal(t, s.GetUser(), "root")
+
+	s.Dsn = "domain.com:2222"
+	require.Equal(t, "", s.GetUser())
 }
 
 func TestServer_GetHost(t *testing.T) {
@@ -66,4 +69,17 @@ func TestServer_GetHost(t *testing.T) {
 	}
 
 	require.Equal(t, s.GetHost(), "domain.com")
+
+	s.Dsn = "domain.com:2222"
+	require.Equal(t, "domain.com", s.GetHost())
+}
+
+func TestServer_Via(t *testing.T) {
+	s := &server{
+		Name: "private",
+		Dsn:  "root@10.0.0.2",
+	}
+	s.Via("bastion")
+
+	require.Equal(t, "bastion", s.via)
 }
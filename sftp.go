@@ -0,0 +1,309 @@
+package exec
+
+import (
+	"fmt"
+	"github.com/pkg/sftp"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// progressStep is the number of bytes transferred between two progress reports of a file
+const progressStep = 1 << 20
+
+// Upload uploads a file or directory from local to remote over SFTP, preserving modes and modification times;
+// a directory is copied recursively as the remote path
+func (c *Ctx) Upload(local, remote string) error {
+	local, remote = c.Parse(local), c.Parse(remote)
+
+	return c.transfer(fmt.Sprintf("upload (local)%s > (remote)%s", local, remote), func(client *sftp.Client) error {
+		return upload(client, expandHome(local), c.remotePath(client, remote), c.progress)
+	})
+}
+
+// Download downloads a file or directory from remote to local over SFTP, preserving modes and modification times;
+// a directory is copied recursively as the local path
+func (c *Ctx) Download(remote, local string) error {
+	local, remote = c.Parse(local), c.Parse(remote)
+
+	return c.transfer(fmt.Sprintf("download (remote)%s > (local)%s", remote, local), func(client *sftp.Client) error {
+		return download(client, c.remotePath(client, remote), expandHome(local), c.progress)
+	})
+}
+
+// transfer runs f with the SFTP client of the server of the invocation
+func (c *Ctx) transfer(description string, f func(client *sftp.Client) error) (err error) {
+	if err := c.abortErr(); err != nil {
+		return err
+	}
+
+	run, onServers := c.exec.shouldIRun(c.task)
+
+	if !run {
+		c.exec.commandNotAllowedToRunPrint(onServers, description)
+		return nil
+	}
+
+	if c.server == nil {
+		// like a remote command, a task running on servers called the exec helpers while running in parallel
+		if c.task != nil || c.exec.running() {
+			err = ErrNoServer
+			c.exec.reporter.OnError("local", err)
+			c.fail(err)
+		}
+		return err
+	}
+
+	if c.exec.dryRun {
+		c.exec.reporter.OnMessage(c.server.Name, fmt.Sprintf("dry-run on %s `%s`", c.server.Dsn, description))
+		c.exec.emit(Event{Type: TransferFinished, Task: c.taskName(), Server: c.server.Name, Command: description, DryRun: true})
+		return nil
+	}
+
+	c.exec.reporter.OnCommand(c.server.Name, description)
+	c.exec.emit(Event{Type: TransferStarted, Task: c.taskName(), Server: c.server.Name, Command: description})
+
+	defer func(start time.Time) {
+		c.exec.emit(Event{Type: TransferFinished, Task: c.taskName(), Server: c.server.Name, Command: description, Duration: time.Since(start), Error: eventErr(err)})
+	}(time.Now())
+
+	defer func() {
+		if err != nil {
+			c.exec.reporter.OnError(c.server.Name, err)
+			c.fail(err)
+		}
+	}()
+
+	if err = c.connect(c.ctx); err != nil {
+		return err
+	}
+
+	client, err := c.server.sshClient.SFTP()
+	if err != nil {
+		return err
+	}
+
+	return f(client)
+}
+
+// remotePath resolves a remote path, joining a relative path to the working dir of the invocation
+// and expanding a leading ~ to the home dir
+func (c *Ctx) remotePath(client *sftp.Client, p string) string {
+	if dir := c.Dir(); !path.IsAbs(p) && !strings.HasPrefix(p, "~") && dir != "" {
+		p = path.Join(dir, p)
+	}
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := client.Getwd(); err == nil {
+			p = path.Join(home, p[1:])
+		}
+	}
+	return p
+}
+
+// progress displays the progress of a file transfer
+func (c *Ctx) progress(name string, written, total int64) {
+	if written == total {
+		c.exec.reporter.OnMessage(c.server.Name, fmt.Sprintf("%s (%d bytes)", name, total))
+	} else {
+		c.exec.reporter.OnMessage(c.server.Name, fmt.Sprintf("%s %d%%", name, written*100/total))
+	}
+}
+
+// progressFunc is called while a file is transferred
+type progressFunc func(name string, written, total int64)
+
+// progressWriter counts the bytes written and reports them every progressStep and at the end
+type progressWriter struct {
+	name     string
+	total    int64
+	written  int64
+	reported int64
+	report   progressFunc
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+	if p.written < p.total && p.written-p.reported >= progressStep {
+		p.reported = p.written
+		p.report(p.name, p.written, p.total)
+	}
+	return len(b), nil
+}
+
+// copyFile copies src to dst, reporting its progress
+func copyFile(dst io.Writer, src io.Reader, name string, size int64, report progressFunc) error {
+	p := &progressWriter{name: name, total: size, report: report}
+	if _, err := io.Copy(dst, io.TeeReader(src, p)); err != nil {
+		return err
+	}
+	report(name, p.written, p.written)
+	return nil
+}
+
+// upload copies the local file or directory to the remote path
+func upload(client *sftp.Client, local, remote string, report progressFunc) error {
+	info, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return uploadFile(client, local, remote, info, report)
+	}
+
+	var dirs []string
+	err = filepath.Walk(local, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(local, p)
+		if err != nil {
+			return err
+		}
+		target := path.Join(remote, filepath.ToSlash(rel))
+
+		if info.IsDir() {
+			if err := client.MkdirAll(target); err != nil {
+				return err
+			}
+			dirs = append(dirs, p)
+			return nil
+		}
+
+		return uploadFile(client, p, target, info, report)
+	})
+	if err != nil {
+		return err
+	}
+
+	// dirs modes and times are set last, as writing files changes them
+	for i := len(dirs) - 1; i >= 0; i-- {
+		info, err := os.Stat(dirs[i])
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(local, dirs[i])
+		target := path.Join(remote, filepath.ToSlash(rel))
+		if err := client.Chmod(target, info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := client.Chtimes(target, info.ModTime(), info.ModTime()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// uploadFile copies a local file to the remote path
+func uploadFile(client *sftp.Client, local, remote string, info os.FileInfo, report progressFunc) error {
+	src, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := client.OpenFile(remote, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+
+	if err := copyFile(dst, src, local, info.Size(), report); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	if err := client.Chmod(remote, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return client.Chtimes(remote, info.ModTime(), info.ModTime())
+}
+
+// download copies the remote file or directory to the local path
+func download(client *sftp.Client, remote, local string, report progressFunc) error {
+	info, err := client.Stat(remote)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return downloadFile(client, remote, local, info, report)
+	}
+
+	var (
+		dirs   []string
+		walker = client.Walk(remote)
+	)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return err
+		}
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), remote), "/")
+		target := filepath.Join(local, filepath.FromSlash(rel))
+
+		if walker.Stat().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			dirs = append(dirs, walker.Path())
+			continue
+		}
+
+		if err := downloadFile(client, walker.Path(), target, walker.Stat(), report); err != nil {
+			return err
+		}
+	}
+
+	// dirs modes and times are set last, as writing files changes them
+	for i := len(dirs) - 1; i >= 0; i-- {
+		info, err := client.Stat(dirs[i])
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(dirs[i], remote), "/")
+		target := filepath.Join(local, filepath.FromSlash(rel))
+		if err := os.Chmod(target, info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Chtimes(target, info.ModTime(), info.ModTime()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// downloadFile copies a remote file to the local path
+func downloadFile(client *sftp.Client, remote, local string, info os.FileInfo, report progressFunc) error {
+	src, err := client.Open(remote)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(local, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if err := copyFile(dst, src, remote, info.Size(), report); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(local, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(local, info.ModTime(), info.ModTime())
+}
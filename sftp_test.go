@@ -0,0 +1,165 @@
+package exec
+
+import (
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newPipeSFTPClient returns a SFTP client connected to an in-process server serving the local filesystem
+func newPipeSFTPClient(t *testing.T) (*sftp.Client, func()) {
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverR, serverW})
+	require.NoError(t, err)
+	go func() { _ = server.Serve() }()
+
+	client, err := sftp.NewClientPipe(clientR, clientW)
+	require.NoError(t, err)
+
+	return client, func() {
+		_ = server.Close()
+		_ = client.Close()
+	}
+}
+
+func TestTransfer_UploadDownload(t *testing.T) {
+	client, closeClient := newPipeSFTPClient(t)
+	defer closeClient()
+
+	dir, err := ioutil.TempDir("", "exec-sftp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	src := filepath.Join(dir, "src")
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "sub"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "a.txt"), []byte("a"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "sub", "b.sh"), []byte("#!/bin/sh"), 0755))
+	require.NoError(t, os.Chtimes(filepath.Join(src, "sub", "b.sh"), mtime, mtime))
+
+	var reported []string
+	report := func(name string, written, total int64) {
+		if written == total {
+			reported = append(reported, filepath.Base(name))
+		}
+	}
+
+	remote := filepath.Join(dir, "remote")
+	require.NoError(t, upload(client, src, remote, report))
+
+	local := filepath.Join(dir, "local")
+	require.NoError(t, download(client, remote, local, report))
+
+	for _, root := range []string{remote, local} {
+		content, err := ioutil.ReadFile(filepath.Join(root, "sub", "b.sh"))
+		require.NoError(t, err)
+		require.Equal(t, "#!/bin/sh", string(content))
+
+		info, err := os.Stat(filepath.Join(root, "sub", "b.sh"))
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0755), info.Mode().Perm())
+		require.True(t, mtime.Equal(info.ModTime()))
+
+		info, err = os.Stat(filepath.Join(root, "a.txt"))
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	require.ElementsMatch(t, []string{"a.txt", "b.sh", "a.txt", "b.sh"}, reported)
+
+	require.Error(t, upload(client, filepath.Join(dir, "missing"), remote, report))
+	require.Error(t, download(client, filepath.Join(dir, "missing"), local, report))
+}
+
+func TestProgressWriter(t *testing.T) {
+	var reports []int64
+	p := &progressWriter{
+		name:  "file",
+		total: 3 * progressStep,
+		report: func(name string, written, total int64) {
+			reports = append(reports, written)
+		},
+	}
+
+	_, _ = p.Write(make([]byte, progressStep/2))
+	_, _ = p.Write(make([]byte, progressStep))
+	_, _ = p.Write(make([]byte, progressStep))
+	_, _ = p.Write(make([]byte, progressStep/2))
+
+	require.Equal(t, []int64{3 * progressStep / 2, 5 * progressStep / 2}, reports)
+}
+
+func TestCtx_remotePath(t *testing.T) {
+	client, closeClient := newPipeSFTPClient(t)
+	defer closeClient()
+
+	home, err := client.Getwd()
+	require.NoError(t, err)
+
+	e := New()
+	c := e.newCtx(nil, nil)
+
+	require.Equal(t, "/etc/hosts", c.remotePath(client, "/etc/hosts"))
+	require.Equal(t, "file", c.remotePath(client, "file"))
+	require.Equal(t, filepath.Join(home, "file"), c.remotePath(client, "~/file"))
+
+	c.Cd("~/app")
+	require.Equal(t, filepath.Join(home, "app", "file"), c.remotePath(client, "file"))
+	require.Equal(t, "/etc/hosts", c.remotePath(client, "/etc/hosts"))
+}
+
+func TestCtx_UploadDownloadHome(t *testing.T) {
+	home, cleanup := newTestHome(t, nil)
+	defer cleanup()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(home, "app.tar"), []byte("app"), 0600))
+	server := newTestSSHServer(t)
+	defer server.close()
+
+	e := New()
+	e.HostKeyPolicy(InsecureHostKeys)
+	e.Server("s", "root@"+server.addr()).Password("secret")
+
+	remote := filepath.Join(home, "remote.tar")
+	var uploaded, downloaded error
+	e.TaskCtx("transfer", func(c *Ctx) {
+		uploaded = c.Upload("~/app.tar", remote)
+		downloaded = c.Download(remote, "~/downloaded.tar")
+	}).OnServers(func() []string {
+		return []string{"s"}
+	})
+	defer e.Servers["s"].sshClient.Close()
+
+	require.NoError(t, e.Tasks["transfer"].run())
+	require.NoError(t, uploaded)
+	require.NoError(t, downloaded)
+
+	content, err := ioutil.ReadFile(filepath.Join(home, "downloaded.tar"))
+	require.NoError(t, err)
+	require.Equal(t, "app", string(content))
+}
+
+func TestCtx_TransferWithoutServer(t *testing.T) {
+	e := New()
+
+	var err error
+	e.TaskCtx("local", func(c *Ctx) {
+		err = c.Upload("app.tar", "/tmp/app.tar")
+	})
+
+	require.NoError(t, e.Tasks["local"].run())
+	require.Equal(t, ErrNoServer, err)
+	require.Equal(t, ErrNoServer, e.Tasks["local"].results[0].err)
+
+	require.NoError(t, e.Upload("app.tar", "/tmp/app.tar"))
+}
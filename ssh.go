@@ -1,37 +1,49 @@
 package exec
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"net"
 	"os"
 	"os/user"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/agent"
 )
 
 // Client is a wrapper over the SSH connection/sessions.
 type sshClient struct {
-	conn               *ssh.Client
-	sess               *ssh.Session
-	user               string
-	host               string
-	remoteStdin        io.WriteCloser
-	remoteStdout       io.Reader
-	remoteStderr       io.Reader
-	connOpened         bool
-	sessOpened         bool
-	running            bool
-	env                string //export FOO="bar"; export BAR="baz";
-	keys               []string
-	authMethod         ssh.AuthMethod
-	initAuthMethodOnce sync.Once
+	conn              *ssh.Client
+	sess              *ssh.Session
+	sftp              *sftp.Client
+	user              string
+	host              string
+	remoteStdin       io.WriteCloser
+	remoteStdout      io.Reader
+	remoteStderr      io.Reader
+	connOpened        bool
+	sessOpened        bool
+	running           bool
+	pty               bool
+	keys              []string
+	auth              []ssh.AuthMethod
+	authTried         []string
+	hostKeyCallback   ssh.HostKeyCallback
+	hostKeyAlgorithms func(address string) []string
+	keepAlive         time.Duration
+	connectRetry      retryPolicy
+	onConnectRetry    func(attempt int, delay time.Duration, err error)
+	connectMu         sync.Mutex
+	connecting        chan struct{}
+	sessionMu         sync.Mutex
 }
 
+// dialTimeout bounds how long connecting to an unreachable host takes, before it is attempted again
+const dialTimeout = 15 * time.Second
+
 type errConnect struct {
 	User   string
 	Host   string
@@ -77,38 +89,6 @@ func (c *sshClient) parseHost(host string) error {
 	return nil
 }
 
-// initAuthMethod initiates SSH authentication method.
-func (c *sshClient) initAuthMethod() {
-	var signers []ssh.Signer
-
-	// If there's a running SSH Agent, try to use its Private keys.
-	sock, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
-	if err == nil {
-		agent := agent.NewClient(sock)
-		signers, _ = agent.Signers()
-	}
-
-	c.keys = append(c.keys, []string{
-		os.Getenv("HOME") + "/.ssh/id_rsa",
-		os.Getenv("HOME") + "/.ssh/id_dsa",
-	}...)
-
-	// Try to read user's SSH private keys form the standard paths.
-	for _, file := range c.keys {
-		data, err := ioutil.ReadFile(file)
-		if err != nil {
-			continue
-		}
-		signer, err := ssh.ParsePrivateKey(data)
-		if err != nil {
-			continue
-		}
-		signers = append(signers, signer)
-
-	}
-	c.authMethod = ssh.PublicKeys(signers...)
-}
-
 // SSHDialFunc can dial an ssh server and return a client
 type sshDialFunc func(net, addr string, config *ssh.ClientConfig) (*ssh.Client, error)
 
@@ -117,50 +97,185 @@ func (c *sshClient) WithConnection(conn *ssh.Client) {
 	if conn != nil {
 		c.conn = conn
 		c.connOpened = true
+		go c.watch(conn)
 	}
 }
 
 // Connect creates SSH connection to a specified host.
 // It expects the host of the form "[ssh://]host[:port]".
-func (c *sshClient) Connect(host string) error {
-	return c.ConnectWith(host, ssh.Dial)
+func (c *sshClient) Connect(ctx context.Context, host string) error {
+	return c.ConnectWith(ctx, host, ssh.Dial)
 }
 
 // ConnectWith creates a SSH connection to a specified host. It will use dialer to establish the
-// connection.
-func (c *sshClient) ConnectWith(host string, dialer sshDialFunc) error {
-	if c.connOpened {
+// connection, attempting it again with backoff while the host is unreachable, as set by connectRetry,
+// and gives up once ctx is done.
+func (c *sshClient) ConnectWith(ctx context.Context, host string, dialer sshDialFunc) error {
+	if c.connected() {
 		return fmt.Errorf("Already connected")
 	}
 
-	c.initAuthMethodOnce.Do(c.initAuthMethod)
-
 	err := c.parseHost(host)
 	if err != nil {
 		return err
 	}
 
+	if c.hostKeyCallback == nil {
+		return errConnect{c.user, c.host, "no host key verification set"}
+	}
+
 	config := &ssh.ClientConfig{
-		User: c.user,
-		Auth: []ssh.AuthMethod{
-			c.authMethod,
-		},
-		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-			return nil
-		},
+		User:            c.user,
+		Auth:            c.auth,
+		HostKeyCallback: c.hostKeyCallback,
+		Timeout:         dialTimeout,
+	}
+	if c.hostKeyAlgorithms != nil {
+		config.HostKeyAlgorithms = c.hostKeyAlgorithms(c.host)
 	}
 
-	c.conn, err = dialer("tcp", c.host, config)
+	var conn *ssh.Client
+	for attempt := 1; ; attempt++ {
+		conn, err = dial(ctx, dialer, c.host, config)
+		if err == nil || ctx.Err() != nil || !c.connectRetry.retries(attempt) || !retryableConnectErr(err) {
+			break
+		}
+
+		delay := c.connectRetry.delay(attempt)
+		if c.onConnectRetry != nil {
+			c.onConnectRetry(attempt, delay, err)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if ctx.Err() != nil {
+		return contextErr(ctx, err)
+	}
 	if err != nil {
-		return errConnect{c.user, c.host, err.Error()}
+		reason := err.Error()
+		if strings.Contains(reason, "unable to authenticate") {
+			reason += fmt.Sprintf(" (tried: %s)", strings.Join(c.authTried, ", "))
+		}
+		return errConnect{c.user, c.host, reason}
 	}
+
+	c.connectMu.Lock()
+	c.conn = conn
 	c.connOpened = true
+	c.connectMu.Unlock()
+	go c.watch(conn)
+
+	if c.keepAlive > 0 {
+		go keepAlive(conn, c.keepAlive)
+	}
 
 	return nil
 }
 
-// Run runs the task.Run command remotely on c.host.
+// dial connects to host with dialer, unless ctx is done first; the connection made after is closed
+func dial(ctx context.Context, dialer sshDialFunc, host string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	type dialed struct {
+		conn *ssh.Client
+		err  error
+	}
+	done := make(chan dialed, 1)
+	go func() {
+		conn, err := dialer("tcp", host, config)
+		done <- dialed{conn, err}
+	}()
+
+	select {
+	case d := <-done:
+		return d.conn, d.err
+	case <-ctx.Done():
+		go func() {
+			if d := <-done; d.conn != nil {
+				_ = d.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// startConnecting waits for the connection in progress, if any, and returns whether the caller should connect,
+// in which case it must call the returned done func once it has; it stops waiting once ctx is done
+func (c *sshClient) startConnecting(ctx context.Context) (connect bool, done func(), err error) {
+	for {
+		c.connectMu.Lock()
+		if c.connOpened {
+			c.connectMu.Unlock()
+			return false, nil, nil
+		}
+		if c.connecting == nil {
+			connecting := make(chan struct{})
+			c.connecting = connecting
+			c.connectMu.Unlock()
+			return true, func() {
+				c.connectMu.Lock()
+				defer c.connectMu.Unlock()
+				c.connecting = nil
+				close(connecting)
+			}, nil
+		}
+		connecting := c.connecting
+		c.connectMu.Unlock()
+
+		select {
+		case <-connecting:
+		case <-ctx.Done():
+			return false, nil, contextErr(ctx, ctx.Err())
+		}
+	}
+}
+
+// watch marks conn closed once it is dropped, e.g. by a rebooting server, so the next connect dials again
+func (c *sshClient) watch(conn *ssh.Client) {
+	_ = conn.Wait()
+
+	c.connectMu.Lock()
+	defer c.connectMu.Unlock()
+	c.dropped(conn)
+}
+
+// dropped forgets conn if it is still the open connection, with its SFTP client
+func (c *sshClient) dropped(conn *ssh.Client) {
+	if c.conn == conn && c.connOpened {
+		c.connOpened = false
+		c.sftp = nil
+	}
+}
+
+// connected checks if the connection is open
+func (c *sshClient) connected() bool {
+	c.connectMu.Lock()
+	defer c.connectMu.Unlock()
+	return c.connOpened
+}
+
+// keepAlive sends keepalive requests over conn every interval, until it is closed
+func keepAlive(conn *ssh.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, _, err := conn.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+			return
+		}
+	}
+}
+
+// Run runs the task.Run command remotely on c.host, without pty.
 func (c *sshClient) Run(cmd string) error {
+	return c.RunWith(cmd, nil)
+}
+
+// RunWith runs the command remotely on c.host, with the requested pty if any.
+func (c *sshClient) RunWith(cmd string, pty *ptyRequest) error {
 	if c.running {
 		return fmt.Errorf("Session already running")
 	}
@@ -170,6 +285,13 @@ func (c *sshClient) Run(cmd string) error {
 
 	sess, err := c.conn.NewSession()
 	if err != nil {
+		// a session refused by the server keeps the connection usable, any other failure means it is gone
+		if _, ok := err.(*ssh.OpenChannelError); !ok {
+			c.connectMu.Lock()
+			_ = c.conn.Close()
+			c.dropped(c.conn)
+			c.connectMu.Unlock()
+		}
 		return err
 	}
 
@@ -188,25 +310,22 @@ func (c *sshClient) Run(cmd string) error {
 		return err
 	}
 
-	// Set up terminal modes
-	modes := ssh.TerminalModes{
-		ssh.ECHO:          0,     // disable echoing
-		ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
-		ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
-	}
-	// Request pseudo terminal
-	if err := sess.RequestPty("xterm", 80, 40, modes); err != nil {
-		return fmt.Errorf("request for pseudo terminal failed: %s", err)
+	if pty != nil {
+		// Request pseudo terminal
+		if err := sess.RequestPty(pty.term, pty.height, pty.width, pty.modes); err != nil {
+			return fmt.Errorf("request for pseudo terminal failed: %s", err)
+		}
 	}
 
 	// Run the remote command.
-	if err := sess.Start(c.env + cmd); err != nil {
+	if err := sess.Start(cmd); err != nil {
 		return err
 	}
 
 	c.sess = sess
 	c.sessOpened = true
 	c.running = true
+	c.pty = pty != nil
 	return nil
 }
 
@@ -239,12 +358,37 @@ func (c *sshClient) DialThrough(net, addr string, config *ssh.ClientConfig) (*ss
 
 }
 
-// Close closes the underlying SSH connection and session.
+// SFTP returns a SFTP client over the SSH connection, started on first use.
+func (c *sshClient) SFTP() (*sftp.Client, error) {
+	c.connectMu.Lock()
+	defer c.connectMu.Unlock()
+
+	if !c.connOpened {
+		return nil, fmt.Errorf("Trying to start SFTP on a closed connection")
+	}
+	if c.sftp == nil {
+		client, err := sftp.NewClient(c.conn)
+		if err != nil {
+			return nil, err
+		}
+		c.sftp = client
+	}
+	return c.sftp, nil
+}
+
+// Close closes the underlying SSH connection, session and SFTP client.
 func (c *sshClient) Close() error {
+	if c.sftp != nil {
+		_ = c.sftp.Close()
+		c.sftp = nil
+	}
 	if c.sessOpened {
 		c.sess.Close()
 		c.sessOpened = false
 	}
+
+	c.connectMu.Lock()
+	defer c.connectMu.Unlock()
 	if !c.connOpened {
 		return fmt.Errorf("Trying to close the already closed connection")
 	}
@@ -276,6 +420,14 @@ func (c *sshClient) WriteClose() error {
 	return c.remoteStdin.Close()
 }
 
+// WindowChange sends the new size of the local terminal to the pty of the session.
+func (c *sshClient) WindowChange(width, height int) error {
+	if !c.sessOpened {
+		return fmt.Errorf("session is not open")
+	}
+	return c.sess.WindowChange(height, width)
+}
+
 func (c *sshClient) Signal(sig os.Signal) error {
 	if !c.sessOpened {
 		return fmt.Errorf("session is not open")
@@ -288,7 +440,10 @@ func (c *sshClient) Signal(sig os.Signal) error {
 		// which sounds like something that should be fixed/resolved
 		// upstream in the golang.org/x/crypto/ssh pkg.
 		// https://github.com/golang/go/issues/4115#issuecomment-66070418
-		_, _ = c.remoteStdin.Write([]byte("\x03"))
+		// Without pty, \x03 would be read by the command as input.
+		if c.pty {
+			_, _ = c.remoteStdin.Write([]byte("\x03"))
+		}
 		return c.sess.Signal(ssh.SIGINT)
 	default:
 		return fmt.Errorf("%v not supported", sig)
@@ -0,0 +1,179 @@
+package exec
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSSHServer is an in-process ssh server printing back the commands it runs, with the sftp subsystem
+type testSSHServer struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+	mu       sync.Mutex
+	conns    []net.Conn
+}
+
+func newTestSSHServer(t *testing.T) *testSSHServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+
+	s := &testSSHServer{listener: listener, config: &ssh.ServerConfig{NoClientAuth: true}}
+	s.config.AddHostKey(signer)
+	go s.serve()
+	return s
+}
+
+func (s *testSSHServer) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *testSSHServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *testSSHServer) handle(conn net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				_ = req.Reply(true, nil)
+				if req.Type == "subsystem" {
+					if server, err := sftp.NewServer(channel); err == nil {
+						_ = server.Serve()
+					}
+					return
+				}
+				if req.Type != "exec" {
+					continue
+				}
+				var exec struct{ Command string }
+				_ = ssh.Unmarshal(req.Payload, &exec)
+				_, _ = channel.Write([]byte(exec.Command))
+				_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				return
+			}
+		}()
+	}
+}
+
+// drop closes the connections of the clients, as a rebooting server does
+func (s *testSSHServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testSSHServer) close() {
+	_ = s.listener.Close()
+	s.drop()
+}
+
+func TestSshClient_watch(t *testing.T) {
+	server := newTestSSHServer(t)
+	defer server.close()
+
+	c := &sshClient{hostKeyCallback: ssh.InsecureIgnoreHostKey()}
+	require.NoError(t, c.Connect(context.Background(), "root@"+server.addr()))
+	require.True(t, c.connected())
+
+	server.drop()
+	require.Eventually(t, func() bool { return !c.connected() }, time.Second, 10*time.Millisecond)
+	require.Error(t, c.Close())
+}
+
+func TestCtx_RemoteReconnects(t *testing.T) {
+	_, cleanup := newTestHome(t, nil)
+	defer cleanup()
+	server := newTestSSHServer(t)
+	defer server.close()
+
+	e := New()
+	e.HostKeyPolicy(InsecureHostKeys)
+	e.Server("s", "root@"+server.addr()).Password("secret")
+
+	var before, after Output
+	e.TaskCtx("reboot", func(c *Ctx) {
+		before = c.Remote("before")
+		server.drop()
+		after = c.Remote("after")
+	}).OnServers(func() []string {
+		return []string{"s"}
+	})
+	defer e.Servers["s"].sshClient.Close()
+
+	require.NoError(t, e.Tasks["reboot"].run())
+	require.NoError(t, before.Err())
+	require.Equal(t, "before", before.String())
+	require.NoError(t, after.Err())
+	require.Equal(t, "after", after.String())
+}
+
+func TestCtx_RemoteConcurrently(t *testing.T) {
+	_, cleanup := newTestHome(t, nil)
+	defer cleanup()
+	server := newTestSSHServer(t)
+	defer server.close()
+
+	e := New()
+	e.HostKeyPolicy(InsecureHostKeys)
+	e.Server("s", "root@"+server.addr()).Password("secret")
+
+	outputs := make([]Output, 5)
+	e.TaskCtx("concurrent", func(c *Ctx) {
+		var wg sync.WaitGroup
+		for i := range outputs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				c.Setenv("N", fmt.Sprint(i))
+				outputs[i] = c.Remote("echo %d", i)
+			}(i)
+		}
+		wg.Wait()
+	}).OnServers(func() []string {
+		return []string{"s"}
+	})
+	defer e.Servers["s"].sshClient.Close()
+
+	require.NoError(t, e.Tasks["concurrent"].run())
+	for i, o := range outputs {
+		require.NoError(t, o.Err())
+		require.True(t, strings.HasSuffix(o.String(), fmt.Sprintf("echo %d", i)), o.String())
+	}
+}
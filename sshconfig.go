@@ -0,0 +1,156 @@
+package exec
+
+import (
+	"fmt"
+	"github.com/kevinburke/ssh_config"
+	"os"
+	osuser "os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultSSHConfig returns the user's ssh_config file
+func defaultSSHConfig() string {
+	return filepath.Join(os.Getenv("HOME"), ".ssh", "config")
+}
+
+// SSHConfig sets the ssh_config files the servers are resolved from, instead of ~/.ssh/config;
+// it must be called before declaring the servers
+func (e *Exec) SSHConfig(files ...string) {
+	e.sshConfigFiles = files
+	e.sshConfigs = nil
+}
+
+// sshConfig returns the parsed ssh_config files, loaded on first use;
+// a missing default file is ignored
+func (e *Exec) sshConfig() []*ssh_config.Config {
+	if e.sshConfigs != nil {
+		return e.sshConfigs
+	}
+
+	files := e.sshConfigFiles
+	if len(files) == 0 {
+		files = []string{defaultSSHConfig()}
+	}
+
+	e.sshConfigs = []*ssh_config.Config{}
+	for _, file := range files {
+		f, err := os.Open(expandHome(file))
+		if os.IsNotExist(err) && len(e.sshConfigFiles) == 0 {
+			continue
+		}
+		if err != nil {
+			e.reporter.OnError("local", err)
+			continue
+		}
+		cfg, err := ssh_config.Decode(f)
+		_ = f.Close()
+		if err != nil {
+			e.reporter.OnError("local", fmt.Errorf("ssh config %s: %v", file, err))
+			continue
+		}
+		e.sshConfigs = append(e.sshConfigs, cfg)
+	}
+
+	return e.sshConfigs
+}
+
+// sshConfigGet returns the first value of key for the host alias in the ssh_config files
+func (e *Exec) sshConfigGet(alias, key string) string {
+	for _, cfg := range e.sshConfig() {
+		if value, err := cfg.Get(alias, key); err == nil && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// sshConfigGetAll returns all the values of key for the host alias in the ssh_config files
+func (e *Exec) sshConfigGetAll(alias, key string) (values []string) {
+	for _, cfg := range e.sshConfig() {
+		if all, err := cfg.GetAll(alias, key); err == nil {
+			values = append(values, all...)
+		}
+	}
+	return values
+}
+
+// resolveSSHConfig completes the server from the ssh_config entry of its dsn host,
+// like ssh does: the user and port of the dsn win over the User and Port of the config
+func (e *Exec) resolveSSHConfig(s *server) {
+	user, alias, port := splitDsn(s.Dsn)
+
+	host := alias
+	if hostName := e.sshConfigGet(alias, "HostName"); hostName != "" {
+		host = strings.Replace(hostName, "%h", alias, -1)
+	}
+	if user == "" {
+		user = e.sshConfigGet(alias, "User")
+	}
+	// as for ssh, the local user is the default one
+	if u, err := osuser.Current(); user == "" && host != "" && err == nil {
+		user = u.Username
+	}
+	if port == "" {
+		port = e.sshConfigGet(alias, "Port")
+	}
+
+	s.Dsn = host
+	if user != "" {
+		s.Dsn = user + "@" + s.Dsn
+	}
+	if port != "" {
+		s.Dsn += ":" + port
+	}
+
+	// as for ssh, the identity files of the config are skipped when missing
+	for _, file := range e.sshConfigGetAll(alias, "IdentityFile") {
+		file = strings.Replace(file, "%h", alias, -1)
+		if _, err := os.Stat(expandHome(file)); err == nil && !contains(s.sshClient.keys, file) {
+			s.Key(file)
+		}
+	}
+
+	if s.via == "" {
+		if proxyJump := e.sshConfigGet(alias, "ProxyJump"); proxyJump != "" && proxyJump != "none" {
+			s.via = e.jumpHops(strings.Split(proxyJump, ","))
+		}
+	}
+
+	if interval := e.sshConfigGet(alias, "ServerAliveInterval"); interval != "" {
+		if seconds, err := strconv.Atoi(interval); err == nil {
+			s.sshClient.keepAlive = time.Duration(seconds) * time.Second
+		}
+	}
+}
+
+// jumpHops declares the undeclared hops of a ProxyJump chain as jump servers,
+// each one connected via the previous one, and returns the last hop
+func (e *Exec) jumpHops(hops []string) string {
+	var via string
+	for _, hop := range hops {
+		hop = strings.TrimSpace(hop)
+		if _, ok := e.Servers[hop]; !ok {
+			if _, ok := e.jumpServers[hop]; !ok {
+				jump := e.newServer(hop, hop, via)
+				e.jumpServers[hop] = jump
+			}
+		}
+		via = hop
+	}
+	return via
+}
+
+// splitDsn splits a [ssh://][user@]host[:port] dsn
+func splitDsn(dsn string) (user, host, port string) {
+	host = strings.TrimPrefix(dsn, "ssh://")
+	if at := strings.Index(host, "@"); at != -1 {
+		user, host = host[:at], host[at+1:]
+	}
+	if colon := strings.LastIndex(host, ":"); colon != -1 {
+		host, port = host[:colon], host[colon+1:]
+	}
+	return user, host, port
+}
@@ -0,0 +1,158 @@
+package exec
+
+import (
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testSSHConfig = `
+Host prod1
+    HostName 10.0.0.1
+    User deploy
+    Port 2222
+    IdentityFile ~/.ssh/prod
+    IdentityFile ~/.ssh/missing
+    ServerAliveInterval 30
+
+Host private
+    HostName %h.internal
+    ProxyJump bastion2,bastion1
+
+Host bastion1
+    HostName 10.0.0.254
+    User jump
+
+Host *.example.com
+    User admin
+`
+
+// currentUser returns the name of the local user, the default user of the servers
+func currentUser(t *testing.T) string {
+	u, err := user.Current()
+	require.NoError(t, err)
+	return u.Username
+}
+
+func TestExec_ServerFromSSHConfig(t *testing.T) {
+	dir, cleanup := newTestHome(t, map[string][]byte{"prod": []byte("key")})
+	defer cleanup()
+	file := filepath.Join(dir, "ssh_config")
+	require.NoError(t, ioutil.WriteFile(file, []byte(testSSHConfig), 0600))
+
+	testCases := []struct {
+		name      string
+		dsn       string
+		expected  string
+		keys      []string
+		via       string
+		keepAlive time.Duration
+	}{
+		{
+			name:      "prod1",
+			dsn:       "prod1",
+			expected:  "deploy@10.0.0.1:2222",
+			keys:      []string{"~/.ssh/prod"},
+			keepAlive: 30 * time.Second,
+		},
+		{
+			name:      "empty dsn",
+			dsn:       "",
+			expected:  "deploy@10.0.0.1:2222",
+			keys:      []string{"~/.ssh/prod"},
+			keepAlive: 30 * time.Second,
+		},
+		{
+			name:      "dsn user and port win",
+			dsn:       "root@prod1:22",
+			expected:  "root@10.0.0.1:22",
+			keys:      []string{"~/.ssh/prod"},
+			keepAlive: 30 * time.Second,
+		},
+		{
+			name:     "pattern",
+			dsn:      "www.example.com",
+			expected: "admin@www.example.com",
+		},
+		{
+			name:     "proxy jump",
+			dsn:      "private",
+			expected: currentUser(t) + "@private.internal",
+			via:      "bastion1",
+		},
+		{
+			name:     "unknown host",
+			dsn:      "root@domain.com",
+			expected: "root@domain.com",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := New()
+			e.SSHConfig(file)
+			s := e.Server("prod1", tc.dsn)
+
+			require.Equal(t, tc.expected, s.Dsn)
+			require.Equal(t, tc.keys, s.sshClient.keys)
+			require.Equal(t, tc.via, s.via)
+			require.Equal(t, tc.keepAlive, s.sshClient.keepAlive)
+		})
+	}
+}
+
+func TestExec_jumpHops(t *testing.T) {
+	dir, cleanup := newTestHome(t, nil)
+	defer cleanup()
+	file := filepath.Join(dir, "ssh_config")
+	require.NoError(t, ioutil.WriteFile(file, []byte(testSSHConfig), 0600))
+
+	e := New()
+	e.SSHConfig(file)
+	e.Server("bastion2", "root@bastion.domain.com")
+	s := e.Server("private", "private")
+
+	require.Equal(t, "bastion1", s.via)
+	require.NotContains(t, e.jumpServers, "bastion2")
+	require.Contains(t, e.jumpServers, "bastion1")
+	require.Equal(t, "jump@10.0.0.254", e.jumpServers["bastion1"].Dsn)
+	require.Equal(t, "bastion2", e.jumpServers["bastion1"].via)
+}
+
+func TestExec_SSHConfigMissing(t *testing.T) {
+	_, cleanup := newTestHome(t, nil)
+	defer cleanup()
+
+	e := New()
+	s := e.Server("prod1", "prod1")
+	require.Equal(t, currentUser(t)+"@prod1", s.Dsn)
+	require.Equal(t, currentUser(t), s.GetUser())
+	require.Equal(t, "prod1", s.GetHost())
+
+	e.SSHConfig(filepath.Join(os.TempDir(), "exec-missing-ssh-config"))
+	s = e.Server("prod1", "root@prod1")
+	require.Equal(t, "root@prod1", s.Dsn)
+}
+
+func TestSplitDsn(t *testing.T) {
+	testCases := []struct {
+		dsn                    string
+		expUser, expHost, port string
+	}{
+		{dsn: "host", expHost: "host"},
+		{dsn: "ssh://user@host:22", expUser: "user", expHost: "host", port: "22"},
+		{dsn: "user@host", expUser: "user", expHost: "host"},
+		{dsn: "host:2222", expHost: "host", port: "2222"},
+	}
+
+	for _, tc := range testCases {
+		user, host, port := splitDsn(tc.dsn)
+		require.Equal(t, tc.expUser, user)
+		require.Equal(t, tc.expHost, host)
+		require.Equal(t, tc.port, port)
+	}
+}
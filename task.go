@@ -1,6 +1,7 @@
 package exec
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/fatih/color"
@@ -8,6 +9,8 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // errInvalidParams can be returned by a taskFunction to automatically
@@ -32,11 +35,39 @@ type task struct {
 	serverContextF   func() []string
 	before           []*task
 	after            []*task
+	onFailure        []*task
+	fn               func(*Ctx)
+	implicit         bool
 	removeArguments  map[string]string
 	removeOptions    map[string]string
+	parallel         bool
+	parallelLimit    int
+	errorPolicy      errorPolicy
+	timeout          time.Duration
+	retry            retryPolicy
+	pty              bool
+	become           string
+	env              map[string]string
+	results          []taskResult
 }
 
-type taskFunction func()
+type taskFunction func() error
+
+type errorPolicy int
+
+const (
+	// ContinueOnError keeps executing a task when one of its commands fails
+	ContinueOnError errorPolicy = iota + 1
+	// StopOnError aborts a task when one of its commands fails, skipping its next commands and the tasks set to run after it
+	StopOnError
+)
+
+// taskResult is the outcome of a task invocation on one server
+type taskResult struct {
+	server   *server
+	err      error
+	duration time.Duration
+}
 
 func (t *task) ShortDescription(description string) *task {
 	t.shortDescription = description
@@ -112,6 +143,297 @@ func (t *task) OnServers(f func() []string) *task {
 	return t
 }
 
+// Parallel runs the task's func concurrently on all its servers, at most limit at a time;
+// a limit lower than 1 runs on all servers at once
+func (t *task) Parallel(limit int) *task {
+	t.parallel = true
+	t.parallelLimit = limit
+	return t
+}
+
+// ErrorPolicy sets what happens when a command of the task fails, overriding the exec's policy
+func (t *task) ErrorPolicy(policy errorPolicy) *task {
+	t.errorPolicy = policy
+	return t
+}
+
+// policy returns the error policy applied to the task
+func (t *task) policy() errorPolicy {
+	if t.errorPolicy != 0 {
+		return t.errorPolicy
+	}
+	return t.exec.errorPolicy
+}
+
+// Timeout interrupts the task's func if it is still running after d, on each server;
+// the running command is interrupted and the next ones are skipped
+func (t *task) Timeout(d time.Duration) *task {
+	t.timeout = d
+	return t
+}
+
+// Retry attempts the commands of the task up to attempts times while they fail, waiting backoff between attempts,
+// unless overridden by their Retry option
+func (t *task) Retry(attempts int, backoff Backoff) *task {
+	t.retry = retryPolicy{attempts: attempts, backoff: backoff}
+	return t
+}
+
+// Pty requests a pty for all the remote commands of the task, as with their Pty option
+func (t *task) Pty() *task {
+	t.pty = true
+	return t
+}
+
+// Become runs all the remote commands of the task as user with sudo, as with their As option
+func (t *task) Become(user string) *task {
+	t.become = user
+	return t
+}
+
+// Env sets env vars for all the commands of the task, overriding the exec's and server's ones
+func (t *task) Env(env map[string]string) *task {
+	t.env = mergeEnv(t.env, env)
+	return t
+}
+
+// OnFailure sets tasks to run when the task fails under its error policy, on the servers the task was executed on
+func (t *task) OnFailure(tasks ...string) *task {
+	t.exec.OnFailure(t.Name, tasks...)
+	return t
+}
+
+// runLocally executes f in a context without server
+func (t *task) runLocally(f func(*Ctx)) error {
+	ctx := t.exec.newCtx(t, nil)
+	defer t.exec.invoking()()
+	defer t.exec.enter(ctx)()
+
+	start := time.Now()
+	t.exec.reporter.OnTaskStart(t.Name, "")
+	t.exec.emit(Event{Type: TaskStarted, Task: t.Name})
+
+	t.invoke(ctx, f)
+
+	t.results = []taskResult{{err: ctx.Err(), duration: time.Since(start)}}
+	t.exec.reporter.OnTaskEnd(t.Name, "", t.results[0].duration, t.results[0].err)
+	t.exec.emit(Event{Type: TaskFinished, Task: t.Name, Duration: t.results[0].duration, Error: eventErr(t.results[0].err)})
+
+	return t.resultsErr()
+}
+
+// runOnServers executes f once per server, each invocation with its own server context,
+// sequentially or in parallel if requested, and keeps the results of all invocations
+func (t *task) runOnServers(servers []*server, f func(*Ctx)) error {
+	t.results = nil
+
+	if !t.parallel {
+		for _, s := range servers {
+			r := t.runOnServer(s, f)
+			t.results = append(t.results, r)
+			if r.err != nil && (t.policy() == StopOnError || r.err == ErrInterrupted) {
+				break
+			}
+		}
+		return t.resultsErr()
+	}
+
+	// the exec helpers of a func() task act on the current invocation, there's none while running in parallel
+	if t.implicit {
+		err := fmt.Errorf("task %s can't run in parallel, declare it with TaskCtx to use the Ctx of each invocation", t.Name)
+		t.exec.reporter.OnError("local", err)
+		return err
+	}
+	defer t.exec.enter(nil)()
+
+	limit := t.parallelLimit
+	if limit < 1 || limit > len(servers) {
+		limit = len(servers)
+	}
+
+	var (
+		wg          sync.WaitGroup
+		mu          sync.Mutex
+		failed      bool
+		interrupted bool
+		panicked    interface{}
+		sem         = make(chan struct{}, limit)
+	)
+	for _, s := range servers {
+		sem <- struct{}{}
+
+		mu.Lock()
+		stop := (failed && t.policy() == StopOnError) || interrupted
+		mu.Unlock()
+		if stop {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(s *server) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			var r taskResult
+			defer func() {
+				p := recover()
+				if p != nil {
+					r = taskResult{server: s, err: fmt.Errorf("panic: %v", p)}
+				}
+
+				mu.Lock()
+				if p != nil && panicked == nil {
+					panicked = p
+				}
+				t.results = append(t.results, r)
+				failed = failed || r.err != nil
+				interrupted = interrupted || r.err == ErrInterrupted
+				mu.Unlock()
+			}()
+
+			r = t.runOnServer(s, f)
+		}(s)
+	}
+	wg.Wait()
+
+	t.printResults()
+
+	// a panic fails the run whatever the error policy, as in a sequential execution
+	if panicked != nil {
+		panic(panicked)
+	}
+
+	return t.resultsErr()
+}
+
+// runOnServer executes f in the server context of s
+func (t *task) runOnServer(s *server, f func(*Ctx)) taskResult {
+	ctx := t.exec.newCtx(t, s)
+	defer t.exec.invoking()()
+	if !t.parallel {
+		defer t.exec.enter(ctx)()
+	}
+
+	start := time.Now()
+	t.exec.reporter.OnTaskStart(t.Name, s.Name)
+	t.exec.emit(Event{Type: TaskStarted, Task: t.Name, Server: s.Name})
+
+	t.invoke(ctx, f)
+
+	r := taskResult{server: s, err: ctx.Err(), duration: time.Since(start)}
+	t.exec.reporter.OnTaskEnd(t.Name, s.Name, r.duration, r.err)
+	t.exec.emit(Event{Type: TaskFinished, Task: t.Name, Server: s.Name, Duration: r.duration, Error: eventErr(r.err)})
+
+	return r
+}
+
+// invoke executes the task's func within its timeout, stopping it if a command fails with StopOnError
+func (t *task) invoke(ctx *Ctx, f func(*Ctx)) {
+	if t.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx.ctx, cancel = context.WithTimeout(ctx.ctx, t.timeout)
+		defer cancel()
+	}
+	ctx.ctx, ctx.cancel = context.WithCancel(ctx.ctx)
+	defer ctx.cancel()
+
+	//execute task's func
+	f(ctx)
+}
+
+// resultsErr returns an error listing the servers the task failed on, if the errors were not ignored;
+// an interrupted run is never ignored
+func (t *task) resultsErr() error {
+	if t.policy() != StopOnError && !t.interrupted() {
+		return nil
+	}
+
+	var (
+		failed []string
+		first  error
+	)
+	for _, r := range t.results {
+		if r.err != nil {
+			if r.server != nil {
+				failed = append(failed, r.server.Name)
+			} else {
+				failed = append(failed, "local")
+			}
+			if first == nil {
+				first = r.err
+			}
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+
+	return errors.Wrapf(first, "task %s failed on %s", t.Name, failed)
+}
+
+// interrupted checks if the last execution of the task was interrupted by Ctrl-C
+func (t *task) interrupted() bool {
+	for _, r := range t.results {
+		if r.err == ErrInterrupted {
+			return true
+		}
+	}
+	return false
+}
+
+// touched returns the servers the last execution of the task ran on
+func (t *task) touched() (servers []*server) {
+	for _, r := range t.results {
+		if r.server != nil {
+			servers = append(servers, r.server)
+		}
+	}
+	return servers
+}
+
+// rollback runs the onFailure tasks on the servers touched by the failed execution, or locally if none,
+// even if the run was interrupted
+func (t *task) rollback(servers []*server) {
+	if len(t.onFailure) > 0 {
+		t.exec.resume()
+	}
+
+	for _, ft := range t.onFailure {
+		t.exec.reporter.OnMessage("", fmt.Sprintf("➤ Rolling back task %s with task %s", t.Name, ft.Name))
+
+		var err error
+		switch {
+		case ft.fn == nil:
+			err = ft.run()
+		case len(servers) > 0:
+			err = ft.runOnServers(servers, ft.fn)
+		default:
+			err = ft.runLocally(ft.fn)
+		}
+		if err != nil {
+			t.exec.reporter.OnError("local", err)
+		}
+	}
+}
+
+// printResults prints the aggregated results of a parallel execution
+func (t *task) printResults() {
+	var failed int
+	for _, r := range t.results {
+		if r.err != nil {
+			failed++
+		}
+	}
+
+	t.exec.reporter.OnMessage("", fmt.Sprintf("➤ Task %s finished on %d/%d servers", t.Name, len(t.results)-failed, len(t.results)))
+	for _, r := range t.results {
+		if r.err != nil {
+			t.exec.reporter.OnError(r.server.Name, r.err)
+		}
+	}
+}
+
 func (t *task) getOrderedArguments() sortArguments {
 	var args sortArguments
 	for _, argument := range t.Arguments {
@@ -217,33 +539,69 @@ func (t *task) execute(taskName string, cmdArgs []string) error {
 		return nil
 	}
 
+	if t.boolOption("dry-run") {
+		t.exec.DryRun(true)
+	}
+
+	if err := t.exec.setupLog(t.stringOption("log-format"), t.stringOption("log-file")); err != nil {
+		return err
+	}
+
+	// Discovering the servers of the inventory providers
+	err = t.exec.discoverInventory(t.boolOption("refresh-inventory"))
+
 	// Executing the onStart task
-	t.exec.onStart()
+	if err == nil {
+		err = t.exec.onStart()
+	}
 
-	if len(t.before) > 0 {
-		for _, tb := range t.before {
-			tb.run()
+	for _, tb := range t.before {
+		if err != nil {
+			break
 		}
+		err = tb.run()
 	}
 
 	// Runs the task's func
-	t.run()
+	if err == nil {
+		err = t.run()
+	}
 
-	if len(t.after) > 0 {
-		for _, ta := range t.after {
-			ta.run()
+	// Skips the after tasks if the task failed
+	for _, ta := range t.after {
+		if err != nil {
+			break
 		}
+		err = ta.run()
 	}
 
-	// Executing the onEnd task
-	t.exec.onEnd()
+	// Executing the onEnd task, even if the task failed or was interrupted
+	t.exec.resume()
+	if endErr := t.exec.onEnd(); err == nil {
+		err = endErr
+	}
 
 	// Execute it only once if requested
 	if t.once && !t.executedOnce {
 		t.executedOnce = true
 	}
 
-	return nil
+	return err
+}
+
+// boolOption returns the value of a parsed bool option, false if missing
+func (t *task) boolOption(name string) bool {
+	option, ok := t.Options[name]
+	return ok && option.Value != nil && option.Bool()
+}
+
+// stringOption returns the value of a parsed string option, empty if missing
+func (t *task) stringOption(name string) string {
+	option, ok := t.Options[name]
+	if !ok || option.Value == nil {
+		return ""
+	}
+	return option.String()
 }
 
 func (t *task) parseArgs(args []string) error {
@@ -25,3 +25,8 @@ func (t *taskGroup) OnServers(f func() []string) *taskGroup {
 	t.task.serverContextF = f
 	return t
 }
+
+func (t *taskGroup) OnFailure(tasks ...string) *taskGroup {
+	t.task.exec.OnFailure(t.Name, tasks...)
+	return t
+}
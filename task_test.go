@@ -0,0 +1,458 @@
+package exec
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTask_Parallel(t *testing.T) {
+	type testCase struct {
+		test          string
+		limit         int
+		maxConcurrent int32
+	}
+
+	testCases := []testCase{
+		{
+			test:          "bounded",
+			limit:         2,
+			maxConcurrent: 2,
+		},
+		{
+			test:          "unbounded",
+			limit:         0,
+			maxConcurrent: 4,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.test, func(t *testing.T) {
+			e := New()
+
+			names := []string{"s1", "s2", "s3", "s4"}
+			for _, name := range names {
+				e.Server(name, "root@"+name)
+			}
+
+			var (
+				mu            sync.Mutex
+				visited       []string
+				running       int32
+				maxConcurrent int32
+			)
+
+			e.TaskCtx("parallel", func(c *Ctx) {
+				n := atomic.AddInt32(&running, 1)
+				for {
+					m := atomic.LoadInt32(&maxConcurrent)
+					if n <= m || atomic.CompareAndSwapInt32(&maxConcurrent, m, n) {
+						break
+					}
+				}
+
+				time.Sleep(20 * time.Millisecond)
+
+				mu.Lock()
+				visited = append(visited, c.Server().Name)
+				mu.Unlock()
+
+				atomic.AddInt32(&running, -1)
+			}).OnServers(func() []string {
+				return names
+			}).Parallel(testCase.limit)
+
+			e.Tasks["parallel"].run()
+
+			sort.Strings(visited)
+			require.Equal(t, names, visited)
+			require.Equal(t, testCase.maxConcurrent, maxConcurrent)
+			require.Len(t, e.Tasks["parallel"].results, len(names))
+			require.Nil(t, e.ServerContext())
+		})
+	}
+}
+
+func TestTask_ParallelResults(t *testing.T) {
+	e := New()
+	e.Server("ok", "root@ok")
+	e.Server("failed", "root@failed")
+	e.Server("panicked", "root@panicked")
+
+	e.TaskCtx("parallel", func(c *Ctx) {
+		switch c.Server().Name {
+		case "failed":
+			c.fail(errors.New("command failed"))
+		case "panicked":
+			panic("boom")
+		}
+	}).OnServers(func() []string {
+		return []string{"ok", "failed", "panicked"}
+	}).Parallel(0)
+
+	require.PanicsWithValue(t, "boom", func() {
+		_ = e.Tasks["parallel"].run()
+	})
+
+	errs := make(map[string]error)
+	for _, r := range e.Tasks["parallel"].results {
+		errs[r.server.Name] = r.err
+	}
+
+	require.NoError(t, errs["ok"])
+	require.EqualError(t, errs["failed"], "command failed")
+	require.EqualError(t, errs["panicked"], "panic: boom")
+}
+
+func TestTask_ErrorPolicy(t *testing.T) {
+	type testCase struct {
+		test        string
+		execPolicy  errorPolicy
+		taskPolicy  errorPolicy
+		expectedErr bool
+	}
+
+	testCases := []testCase{
+		{
+			test:        "continue by default",
+			expectedErr: false,
+		},
+		{
+			test:        "stop from exec",
+			execPolicy:  StopOnError,
+			expectedErr: true,
+		},
+		{
+			test:        "stop from task",
+			taskPolicy:  StopOnError,
+			expectedErr: true,
+		},
+		{
+			test:        "continue from task overriding exec",
+			execPolicy:  StopOnError,
+			taskPolicy:  ContinueOnError,
+			expectedErr: false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.test, func(t *testing.T) {
+			e := New()
+			if testCase.execPolicy != 0 {
+				e.ErrorPolicy(testCase.execPolicy)
+			}
+
+			var continued bool
+			task := e.TaskCtx("task", func(c *Ctx) {
+				c.Local("exit 1")
+				continued = c.Local("echo continued").String() == "continued"
+			})
+			if testCase.taskPolicy != 0 {
+				task.ErrorPolicy(testCase.taskPolicy)
+			}
+
+			err := task.run()
+
+			require.Equal(t, testCase.expectedErr, err != nil)
+			require.Equal(t, !testCase.expectedErr, continued)
+		})
+	}
+}
+
+func TestTask_ErrorPolicyOnServers(t *testing.T) {
+	e := New()
+	e.ErrorPolicy(StopOnError)
+	e.Server("s1", "root@s1")
+	e.Server("s2", "root@s2")
+
+	var visited int
+	task := e.TaskCtx("task", func(c *Ctx) {
+		visited++
+		c.Local("exit 1")
+	}).OnServers(func() []string {
+		return []string{"s1", "s2"}
+	})
+
+	err := task.run()
+
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "task task failed on")
+	require.Equal(t, 1, visited)
+	require.Len(t, task.results, 1)
+}
+
+func TestTask_executeStopOnError(t *testing.T) {
+	e := New()
+	e.ErrorPolicy(StopOnError)
+
+	var executed []string
+	record := func(name string, command string) func(c *Ctx) {
+		return func(c *Ctx) {
+			executed = append(executed, name)
+			c.Local(command)
+		}
+	}
+
+	e.TaskCtx("onEnd", record("onEnd", "true"))
+	e.TaskCtx("before", record("before", "true"))
+	e.TaskCtx("deploy", record("deploy", "exit 1"))
+	e.TaskCtx("after", record("after", "true"))
+
+	deploy := e.Tasks["deploy"]
+	deploy.before = []*task{e.Tasks["before"]}
+	deploy.after = []*task{e.Tasks["after"]}
+
+	err := deploy.execute("deploy", nil)
+
+	require.Error(t, err)
+	require.Equal(t, []string{"before", "deploy", "onEnd"}, executed)
+}
+
+func TestTask_OnFailure(t *testing.T) {
+	e := New()
+	e.Server("s1", "root@s1")
+	e.Server("s2", "root@s2")
+
+	var deployed, rolledBack []string
+	e.TaskCtx("rollback", func(c *Ctx) {
+		rolledBack = append(rolledBack, c.Server().Name)
+	})
+
+	deploy := e.TaskCtx("deploy", func(c *Ctx) {
+		deployed = append(deployed, c.Server().Name)
+		if c.Server().Name == "s2" {
+			c.Local("exit 1")
+		}
+	}).OnServers(func() []string {
+		return []string{"s1", "s2"}
+	}).OnFailure("rollback")
+
+	require.Equal(t, []string{"rollback"}, e.onFailure["deploy"])
+
+	deploy.onFailure = []*task{e.Tasks["rollback"]}
+
+	// the failed command is ignored with ContinueOnError, the task not failing
+	require.NoError(t, deploy.run())
+	require.Empty(t, rolledBack)
+
+	// the servers run in any order, s1 being skipped when s2 fails first
+	deployed = nil
+	deploy.ErrorPolicy(StopOnError)
+	require.Error(t, deploy.run())
+
+	sort.Strings(deployed)
+	sort.Strings(rolledBack)
+	require.Contains(t, rolledBack, "s2")
+	require.Equal(t, deployed, rolledBack)
+}
+
+func TestTaskGroup_OnFailure(t *testing.T) {
+	e := New()
+	e.ErrorPolicy(StopOnError)
+	e.Server("s1", "root@s1")
+
+	var executed []string
+	e.TaskCtx("rollback", func(c *Ctx) {
+		executed = append(executed, "rollback on "+c.Server().Name)
+	})
+	e.TaskCtx("release", func(c *Ctx) {
+		executed = append(executed, "release")
+	}).OnServers(func() []string {
+		return []string{"s1"}
+	})
+	e.TaskCtx("cache", func(c *Ctx) {
+		executed = append(executed, "cache")
+		c.Local("exit 1")
+	})
+	e.TaskCtx("cleanup", func(c *Ctx) {
+		executed = append(executed, "cleanup")
+	})
+
+	group := e.TaskGroup("deploy", "release", "cache", "cleanup").OnFailure("rollback")
+	group.task.onFailure = []*task{e.Tasks["rollback"]}
+
+	require.Error(t, group.task.run())
+	require.Equal(t, []string{"release", "cache", "rollback on s1"}, executed)
+}
+
+func TestTask_executeDryRun(t *testing.T) {
+	e := New()
+	var output string
+	e.DryRunOutput(func(server, command string) string {
+		return command
+	})
+	tk := e.Task("task", func() {
+		output = e.Local("echo real").String()
+	})
+	tk.Options = mergeOptions(map[string]string{}, tk.Options, globalOpt)
+
+	require.NoError(t, tk.execute("task", []string{"--dry-run"}))
+	require.Equal(t, "echo real", output)
+	require.True(t, e.dryRun)
+}
+
+func TestTask_StopOnErrorInGoroutine(t *testing.T) {
+	e := New()
+	e.ErrorPolicy(StopOnError)
+
+	var sleeping, next Output
+	task := e.TaskCtx("task", func(c *Ctx) {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			sleeping = c.Local("sleep 5")
+		}()
+		go func() {
+			defer wg.Done()
+			time.Sleep(50 * time.Millisecond)
+			c.Local("exit 1")
+		}()
+		wg.Wait()
+
+		next = c.Local("echo next")
+	})
+
+	start := time.Now()
+	require.Error(t, task.run())
+	require.True(t, time.Since(start) < 5*time.Second)
+	require.Equal(t, 1, exitCode(task.results[0].err))
+
+	// the failure interrupted the command of the other goroutine, and skipped the next one
+	require.Equal(t, ErrInterrupted, sleeping.Err())
+	require.Equal(t, task.results[0].err, next.Err())
+	require.Equal(t, "", next.String())
+}
+
+func TestTask_ParallelRequiresCtx(t *testing.T) {
+	e := New()
+	e.Server("s1", "root@s1")
+
+	executed := false
+	e.Task("parallel", func() {
+		executed = true
+	}).OnServers(func() []string {
+		return []string{"s1"}
+	}).Parallel(0)
+
+	require.EqualError(t, e.Tasks["parallel"].run(), "task parallel can't run in parallel, declare it with TaskCtx to use the Ctx of each invocation")
+	require.False(t, executed)
+}
+
+func TestTask_WithinOnServers(t *testing.T) {
+	e := New()
+	e.Server("s1", "root@s1").Set("release", "1")
+	e.Server("s2", "root@s2").Set("release", "2")
+	e.DryRun(true)
+
+	var mu sync.Mutex
+	dirs := map[string][]string{}
+	task := e.TaskCtx("task", func(c *Ctx) {
+		record := func() {
+			mu.Lock()
+			defer mu.Unlock()
+			dirs[c.Server().Name] = append(dirs[c.Server().Name], c.Dir())
+		}
+
+		c.Within("/var/www/releases/{{release}}", func() {
+			c.Within("current", record)
+			record()
+		})
+		record()
+	}).Parallel(2)
+	task.OnServers(func() []string {
+		return []string{"s1", "s2"}
+	})
+
+	require.NoError(t, task.run())
+	require.Equal(t, map[string][]string{
+		"s1": {"/var/www/releases/1/current", "/var/www/releases/1", ""},
+		"s2": {"/var/www/releases/2/current", "/var/www/releases/2", ""},
+	}, dirs)
+
+	// the dir doesn't leak into the next runs of the task
+	dirs = map[string][]string{}
+	require.NoError(t, task.run())
+	require.Equal(t, "", dirs["s1"][2])
+}
+
+func TestTask_Timeout(t *testing.T) {
+	e := New()
+
+	var continued bool
+	task := e.TaskCtx("task", func(c *Ctx) {
+		c.Local("sleep 5")
+		continued = c.Local("echo continued").String() == "continued"
+	}).Timeout(100 * time.Millisecond)
+
+	start := time.Now()
+	require.NoError(t, task.run())
+	require.True(t, time.Since(start) < 5*time.Second)
+	require.False(t, continued)
+	require.Equal(t, ErrTimeout, task.results[0].err)
+}
+
+func TestTask_executeInterrupted(t *testing.T) {
+	e := New()
+	e.Server("s1", "root@s1")
+	e.Server("s2", "root@s2")
+
+	var executed []string
+	e.TaskCtx("onEnd", func(c *Ctx) {
+		executed = append(executed, "onEnd: "+c.Local("echo ok").String())
+	})
+	e.TaskCtx("rollback", func(c *Ctx) {
+		executed = append(executed, "rollback on "+c.Server().Name+": "+c.Local("echo ok").String())
+	})
+	e.TaskCtx("after", func(c *Ctx) {
+		executed = append(executed, "after")
+	})
+	deploy := e.TaskCtx("deploy", func(c *Ctx) {
+		executed = append(executed, "deploy on "+c.Server().Name)
+		go e.interrupt()
+		c.Local("sleep 5")
+		if c.Local("echo continued").String() == "continued" {
+			executed = append(executed, "continued")
+		}
+	}).OnServers(func() []string {
+		return []string{"s1", "s2"}
+	})
+	deploy.onFailure = []*task{e.Tasks["rollback"]}
+	deploy.after = []*task{e.Tasks["after"]}
+
+	err := deploy.execute("deploy", nil)
+
+	require.Error(t, err)
+	require.Regexp(t, `^task deploy failed on \[s[12]\]: interrupted$`, err.Error())
+	require.Len(t, executed, 3)
+	require.Contains(t, executed[0], "deploy on ")
+	require.Contains(t, executed[1], "rollback on ")
+	require.Contains(t, executed[1], ": ok")
+	require.Equal(t, "onEnd: ok", executed[2])
+}
+
+func TestTask_Retry(t *testing.T) {
+	sink := &testSink{}
+
+	e := New()
+	e.AddEventSink(sink)
+	task := e.TaskCtx("task", func(c *Ctx) {
+		c.Local("exit 1")
+		c.Local("exit 2", Retry(1, nil))
+	}).Retry(3, ConstantBackoff(time.Millisecond))
+
+	require.NoError(t, task.run())
+
+	var commands []string
+	for _, event := range sink.events {
+		if event.Type == CommandStarted {
+			commands = append(commands, event.Command)
+		}
+	}
+	require.Equal(t, []string{"exit 1", "exit 1", "exit 1", "exit 2"}, commands)
+	require.Equal(t, "exit status 1", task.results[0].err.Error())
+}
@@ -0,0 +1,212 @@
+package exec
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateKeywords are the actions and builtin funcs of text/template, left to it when found as {{name}}
+var templateKeywords = map[string]bool{
+	"if": true, "else": true, "end": true, "range": true, "with": true, "define": true, "template": true,
+	"block": true, "break": true, "continue": true, "nil": true, "true": true, "false": true,
+	"and": true, "or": true, "not": true, "call": true, "html": true, "index": true, "slice": true, "js": true,
+	"len": true, "print": true, "printf": true, "println": true, "urlquery": true,
+	"eq": true, "ne": true, "lt": true, "le": true, "gt": true, "ge": true,
+}
+
+// templateData is the data of the templates parsed by Render
+type templateData struct {
+	Server  *templateServer
+	Task    *templateTask
+	Args    map[string]interface{}
+	Options map[string]interface{}
+}
+
+// templateServer is the server of the invocation in the templates, as .Server
+type templateServer struct {
+	Name  string
+	Dsn   string
+	User  string
+	Host  string
+	Port  string
+	Roles []string
+}
+
+// templateTask is the task of the invocation in the templates, as .Task
+type templateTask struct {
+	Name string
+}
+
+// StrictParse sets whether Parse reports the unknown {{var}} as errors, instead of leaving them as is
+func (e *Exec) StrictParse(strict bool) {
+	e.strictParse = strict
+}
+
+// Render parses text as a text/template, with the configs as {{var}} or {{config "var"}},
+// the server, task, args and options as .Server, .Task, .Args and .Options,
+// and the default, upper, join, quote, env, now and sha256 funcs;
+// the unknown {{var}} are errors in strict mode, and left as is otherwise
+func (c *Ctx) Render(text string) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	funcs := c.templateFuncs()
+	rewritten, err := c.rewriteVars(text, funcs)
+	if err != nil {
+		return text, err
+	}
+
+	missingKey := "missingkey=default"
+	if c.exec.strictParse {
+		missingKey = "missingkey=error"
+	}
+
+	var out bytes.Buffer
+	tpl, err := template.New("parse").Funcs(funcs).Option(missingKey).Parse(rewritten)
+	if err == nil {
+		err = tpl.Execute(&out, c.templateData())
+	}
+	if err != nil {
+		if c.exec.strictParse {
+			return text, err
+		}
+		// the texts that aren't templates, like shell commands with braces, are parsed as before
+		return c.parseVars(text), nil
+	}
+
+	return out.String(), nil
+}
+
+// rewriteVars rewrites the {{var}} and {{var | filter}} of the configs as {{config "var"}} actions,
+// and the unknown ones as text, or as errors in strict mode
+func (c *Ctx) rewriteVars(text string, funcs template.FuncMap) (string, error) {
+	var err error
+	rewritten := parseVar.ReplaceAllStringFunc(text, func(str string) string {
+		match := parseVar.FindStringSubmatch(str)
+		name, filter := match[1], match[2]
+
+		switch {
+		case c.Has(name) && (filter == "" || funcs[filter] != nil):
+			action := "config " + strconv.Quote(name)
+			if filter != "" {
+				action += " | " + filter
+			}
+			return "{{" + action + "}}"
+		case !c.Has(name) && (strings.HasPrefix(name, ".") || funcs[name] != nil || templateKeywords[name]):
+			return str
+		case c.exec.strictParse:
+			if err == nil {
+				err = errors.Errorf("unknown variable %s", str)
+			}
+			return str
+		default:
+			return "{{" + strconv.Quote(str) + "}}"
+		}
+	})
+	return rewritten, err
+}
+
+// templateFuncs returns the funcs of the templates parsed by Render
+func (c *Ctx) templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"config": c.templateConfig,
+		"default": func(value, given interface{}) interface{} {
+			if isEmpty(given) {
+				return value
+			}
+			return given
+		},
+		"upper": func(s interface{}) string {
+			return strings.ToUpper(fmt.Sprint(s))
+		},
+		"join": func(sep string, list interface{}) string {
+			v := reflect.ValueOf(list)
+			if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+				return fmt.Sprint(list)
+			}
+			items := make([]string, v.Len())
+			for i := range items {
+				items[i] = fmt.Sprint(v.Index(i).Interface())
+			}
+			return strings.Join(items, sep)
+		},
+		"quote": func(s interface{}) string {
+			return shellQuote(fmt.Sprint(s))
+		},
+		"env": os.Getenv,
+		"now": time.Now,
+		"sha256": func(s interface{}) string {
+			sum := sha256.Sum256([]byte(fmt.Sprint(s)))
+			return hex.EncodeToString(sum[:])
+		},
+	}
+}
+
+// templateConfig returns the parsed value of a config, an unknown one being an error in strict mode
+func (c *Ctx) templateConfig(name string) (string, error) {
+	if !c.Has(name) {
+		if c.exec.strictParse {
+			return "", errors.Errorf("unknown config %q", name)
+		}
+		return "", nil
+	}
+	return c.Render(c.Get(name).String())
+}
+
+// templateData returns the server, task, args and options of the invocation for the templates
+func (c *Ctx) templateData() templateData {
+	data := templateData{
+		Args:    make(map[string]interface{}),
+		Options: make(map[string]interface{}),
+	}
+
+	if s := c.server; s != nil {
+		user, host, port := splitDsn(s.Dsn)
+		data.Server = &templateServer{Name: s.Name, Dsn: s.Dsn, User: user, Host: host, Port: port, Roles: s.roles}
+	}
+
+	arguments, options := c.exec.Arguments, c.exec.Options
+	if c.task != nil {
+		data.Task = &templateTask{Name: c.task.Name}
+		arguments, options = c.task.Arguments, c.task.Options
+	}
+	for name, arg := range arguments {
+		data.Args[name] = arg.Value
+		if arg.Value == nil {
+			data.Args[name] = arg.Default
+		}
+	}
+	for name, opt := range options {
+		data.Options[name] = opt.Default
+		if v := reflect.ValueOf(opt.Value); v.Kind() == reflect.Ptr && !v.IsNil() {
+			data.Options[name] = v.Elem().Interface()
+		}
+	}
+
+	return data
+}
+
+// isEmpty returns whether value is nil or the zero value of its type, or an empty slice or map
+func isEmpty(value interface{}) bool {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Slice, reflect.Map, reflect.Array, reflect.String:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	default:
+		return reflect.DeepEqual(value, reflect.Zero(v.Type()).Interface())
+	}
+}
@@ -0,0 +1,91 @@
+package exec
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestCtx_Render(t *testing.T) {
+	e := New()
+	e.Set("name", "exec")
+	e.Set("bin/mysql", "mysql {{name}}")
+	e.Set("empty", "")
+	e.Set("env", "prod")
+
+	s := e.Server("prod1", "deploy@prod1.domain.com:2222").AddRole("web").AddRole("db")
+
+	stage := e.NewArgument("stage", "")
+	stage.Default = "qa"
+	force := e.NewOption("force", "")
+	force.Default = false
+	tk := e.Task("deploy", func() {}).AddArgument(stage).AddOption(force)
+
+	_ = os.Setenv("EXEC_TEMPLATE_TEST", "from env")
+	defer os.Unsetenv("EXEC_TEMPLATE_TEST")
+
+	c := e.newCtx(tk, s)
+
+	testCases := []struct {
+		text     string
+		expected string
+	}{
+		{text: "no template", expected: "no template"},
+		{text: "{{ name }} {{bin/mysql}} {{env}}", expected: "exec mysql exec prod"},
+		{text: "{{name | quote}} {{ name | upper }}", expected: "'exec' EXEC"},
+		{text: "{{missing}} {{missing | quote}} {{name | unknown}}", expected: "{{missing}} {{missing | quote}} {{name | unknown}}"},
+		{text: `{{config "bin/mysql" | quote}}`, expected: "'mysql exec'"},
+		{text: `{{config "empty" | default "none"}} {{config "name" | default "none"}}`, expected: "none exec"},
+		{text: "{{.Server.Name}} {{.Server.User}}@{{.Server.Host}}:{{.Server.Port}} {{.Server.Roles | join \",\"}}", expected: "prod1 deploy@prod1.domain.com:2222 web,db"},
+		{text: "{{.Task.Name}} {{.Args.stage}} {{.Options.force}}", expected: "deploy qa false"},
+		{text: "{{if .Options.force}}--force{{else}}--safe{{end}}", expected: "--safe"},
+		{text: `{{env "EXEC_TEMPLATE_TEST" | quote}}`, expected: "'from env'"},
+		{text: `{{sha256 "exec"}}`, expected: "2706c619fe73f0cf112473c6ee02e66c04e1c01c110b0c37b88d8eb509630c9f"},
+		{text: `{{now.Year | printf "%d" | len}}`, expected: "4"},
+		{text: "docker inspect -f '{{.State.Running}}' {{name}}", expected: "docker inspect -f '{{.State.Running}}' exec"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.text, func(t *testing.T) {
+			parsed, err := c.Render(tc.text)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, parsed)
+		})
+	}
+}
+
+func TestCtx_RenderStrict(t *testing.T) {
+	e := New()
+	e.StrictParse(true)
+	e.Set("name", "exec")
+
+	c := e.newCtx(nil, nil)
+
+	parsed, err := c.Render("{{name}} {{.Args}}")
+	require.NoError(t, err)
+	require.Equal(t, "exec map[]", parsed)
+
+	testCases := []struct {
+		text string
+		err  string
+	}{
+		{text: "echo {{missing}}", err: "unknown variable {{missing}}"},
+		{text: `echo {{config "missing"}}`, err: `unknown config "missing"`},
+		{text: "echo {{.Args.missing}}", err: `map has no entry for key "missing"`},
+		{text: "echo {{.Server.Host}}", err: "nil pointer evaluating"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.text, func(t *testing.T) {
+			parsed, err := c.Render(tc.text)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.err)
+			require.Equal(t, tc.text, parsed)
+		})
+	}
+
+	o := c.Local("echo {{missing}}")
+	require.EqualError(t, o.Err(), "unknown variable {{missing}}")
+	require.Equal(t, "", o.String())
+	require.Error(t, c.Err())
+}
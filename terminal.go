@@ -0,0 +1,143 @@
+package exec
+
+import (
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// ptyRequest is the pseudo terminal requested for a remote command
+type ptyRequest struct {
+	term   string
+	width  int
+	height int
+	modes  ssh.TerminalModes
+}
+
+// defaultPty is the pty of the remote commands requesting one without being attached to the local terminal
+var defaultPty = &ptyRequest{
+	term:   "xterm",
+	width:  80,
+	height: 40,
+	modes: ssh.TerminalModes{
+		ssh.ECHO:          0,     // disable echoing
+		ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
+		ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
+	},
+}
+
+// attachTerminal puts the local terminal in raw mode for an interactive remote command,
+// and returns a pty of its size and type, with a func restoring it;
+// without a local terminal, the default pty is returned
+func attachTerminal() (pty *ptyRequest, restore func(), err error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return defaultPty, func() {}, nil
+	}
+
+	width, height, err := terminal.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	term := os.Getenv("TERM")
+	if term == "" {
+		term = "xterm"
+	}
+
+	var once sync.Once
+	restore = func() {
+		once.Do(func() {
+			_ = terminal.Restore(fd, state)
+		})
+	}
+
+	return &ptyRequest{term: term, width: width, height: height, modes: ssh.TerminalModes{}}, restore, nil
+}
+
+// forwardResizes sends the size of the local terminal to the pty of the remote session each time it changes;
+// the returned func stops forwarding
+func forwardResizes(c *sshClient) (stop func()) {
+	resized := make(chan os.Signal, 1)
+	notifyResize(resized)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		for {
+			select {
+			case <-resized:
+				if width, height, err := terminal.GetSize(int(os.Stdout.Fd())); err == nil {
+					_ = c.WindowChange(width, height)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(resized)
+		close(done)
+		<-stopped
+	}
+}
+
+// attach copies the output of an interactive command to the local stdout and stderr, as is
+func attach(stdout, stderr io.Reader) error {
+	errC := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(os.Stderr, stderr)
+		errC <- err
+	}()
+
+	_, err := io.Copy(os.Stdout, stdout)
+	if sErr := <-errC; sErr != nil && err == nil {
+		err = sErr
+	}
+
+	return err
+}
+
+// forwardStdin copies the local stdin to w until the returned func is called;
+// the stdin is only read once it has input, so the input typed after the command is left to the next reader
+func forwardStdin(w io.Writer) (stop func(), err error) {
+	stdin := os.Stdin
+	waiter, err := newStdinWaiter(stdin)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		defer waiter.done()
+
+		buf := make([]byte, 1024)
+		for {
+			if ready, err := waiter.wait(); !ready || err != nil {
+				return
+			}
+			n, err := stdin.Read(buf)
+			if n > 0 {
+				if _, err := w.Write(buf[:n]); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return waiter.cancel, nil
+}
@@ -0,0 +1,58 @@
+package exec
+
+import (
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh/terminal"
+	"io"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestAttachTerminalWithoutTerminal(t *testing.T) {
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		t.Skip("skipping test: stdin is a terminal")
+	}
+
+	pty, restore, err := attachTerminal()
+	require.NoError(t, err)
+	require.Equal(t, defaultPty, pty)
+	restore()
+}
+
+func TestForwardStdin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping test: the stdin can't be waited for on Windows")
+	}
+
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() {
+		os.Stdin = stdin
+		_ = r.Close()
+		_ = w.Close()
+	}()
+
+	remote, remoteW := io.Pipe()
+	stop, err := forwardStdin(remoteW)
+	require.NoError(t, err)
+
+	buf := make([]byte, 16)
+	_, err = w.Write([]byte("during"))
+	require.NoError(t, err)
+	n, err := remote.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "during", string(buf[:n]))
+
+	stop()
+
+	_, err = w.Write([]byte("after"))
+	require.NoError(t, err)
+	require.NoError(t, r.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err = r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "after", string(buf[:n]))
+}
@@ -0,0 +1,74 @@
+//go:build !windows
+// +build !windows
+
+package exec
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// notifyResize relays to c the resizes of the local terminal
+func notifyResize(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGWINCH)
+}
+
+// stdinWaiter waits for input on the local stdin, until cancelled through its pipe
+type stdinWaiter struct {
+	stdin  *os.File
+	r, w   *os.File
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newStdinWaiter(stdin *os.File) (*stdinWaiter, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	return &stdinWaiter{stdin: stdin, r: r, w: w, closed: make(chan struct{})}, nil
+}
+
+// wait returns true once the stdin has input, or false once cancelled
+func (s *stdinWaiter) wait() (bool, error) {
+	fds := []unix.PollFd{
+		{Fd: int32(s.r.Fd()), Events: unix.POLLIN},
+		{Fd: int32(s.stdin.Fd()), Events: unix.POLLIN},
+	}
+	for {
+		_, err := unix.Poll(fds, -1)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if fds[0].Revents != 0 {
+			return false, nil
+		}
+		if fds[1].Revents != 0 {
+			return true, nil
+		}
+	}
+}
+
+// cancel wakes up wait by closing the write end of the pipe, and waits until the stdin is no longer read
+func (s *stdinWaiter) cancel() {
+	s.once.Do(func() {
+		_ = s.w.Close()
+	})
+	<-s.closed
+}
+
+// done releases the pipe, once the stdin is no longer read
+func (s *stdinWaiter) done() {
+	s.once.Do(func() {
+		_ = s.w.Close()
+	})
+	_ = s.r.Close()
+	close(s.closed)
+}
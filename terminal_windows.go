@@ -0,0 +1,37 @@
+package exec
+
+import (
+	"os"
+	"sync"
+)
+
+// notifyResize does nothing, as the resizes of the local terminal are not signaled on Windows
+func notifyResize(c chan<- os.Signal) {}
+
+// stdinWaiter can't wait for input on Windows, so a read of the stdin pending on cancel ends on the next input
+type stdinWaiter struct {
+	cancelled chan struct{}
+	once      sync.Once
+}
+
+func newStdinWaiter(stdin *os.File) (*stdinWaiter, error) {
+	return &stdinWaiter{cancelled: make(chan struct{})}, nil
+}
+
+// wait returns true until cancelled
+func (s *stdinWaiter) wait() (bool, error) {
+	select {
+	case <-s.cancelled:
+		return false, nil
+	default:
+		return true, nil
+	}
+}
+
+func (s *stdinWaiter) cancel() {
+	s.once.Do(func() {
+		close(s.cancelled)
+	})
+}
+
+func (s *stdinWaiter) done() {}
@@ -4,48 +4,49 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
-	"github.com/fatih/color"
 	"github.com/satori/go.uuid"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"reflect"
-	"regexp"
 	"strings"
 	"text/template"
 )
 
 // Cd is a remote helper function that runs a `cd` before a command
 func (e *Exec) Cd(path string) {
-	dir := e.Parse(path)
-	if e.TaskContext != nil {
-		e.TaskContext.Dir = dir
-	}
-	if e.ServerContext != nil {
-		command := "cd " + dir
-		color.Green("[%s] %s %s", e.ServerContext.Name, color.GreenString(">"), command)
-		e.ServerContext.sshClient.env = command + "; "
-	}
+	e.current().Cd(path)
+}
+
+// PushDir is a helper function that cds into dir for the next commands, until PopDir
+func (e *Exec) PushDir(dir string) {
+	e.current().PushDir(dir)
+}
+
+// PopDir is a helper function that restores the working dir saved by the last PushDir
+func (e *Exec) PopDir() {
+	e.current().PopDir()
+}
+
+// Within is a helper function that runs f with dir as the working dir, restoring the previous one afterwards
+func (e *Exec) Within(dir string, f func()) {
+	e.current().Within(dir, f)
 }
 
 // CommandExist checks if a remote command exists on server
 func (e *Exec) CommandExist(command string) bool {
-	return e.Remote("if hash %s 2>/dev/null; then echo 'true'; fi", command).Bool()
+	return e.Remote("hash %s 2>/dev/null && echo 'true'", command, ExitCodes(1)).Bool()
 }
 
-// Parse parses {{var}} with Get(var)
+// Parse parses a text template with the configs as {{var}}, like Render, reporting its error
 func (e *Exec) Parse(text string) string {
-	re := regexp.MustCompile(`\{\{\s*([\w\.\/]+)\s*\}\}`)
-	if !re.MatchString(text) {
-		return text
-	}
-	return re.ReplaceAllStringFunc(text, func(str string) string {
-		name := strings.TrimSuffix(strings.TrimPrefix(str, "{{"), "}}")
-		if e.Has(name) {
-			return e.Parse(e.Get(name).String())
-		}
-		return str
-	})
+	return e.current().Parse(text)
+}
+
+// Render parses a text template with the configs as {{var}}, and the server, task, args, options and funcs
+func (e *Exec) Render(text string) (string, error) {
+	return e.current().Render(text)
 }
 
 // RemoteRunIfNoBinary runs a remote command if a binary is not found
@@ -103,59 +104,76 @@ func (e *Exec) LocalRunIfs(config map[string]interface{}) {
 // UploadFileSudo uploads a local file to a remote file with sudo
 func (e *Exec) UploadFileSudo(source, destination string) {
 	tempFile := "/tmp/" + uuid.NewV4().String()
-	e.Upload(source, tempFile)
-	e.Remote("sudo mv %s %s", tempFile, destination)
+	if err := e.Upload(source, tempFile); err == nil {
+		e.Remote("mv %s %s", tempFile, destination, Sudo())
+	}
 }
 
 // UploadTemplateFileSudo parses a local template file with context, and uploads it to a remote file with sudo
 func (e *Exec) UploadTemplateFileSudo(source, destination string, context interface{}) {
+	if e.dryRunHelper("upload template (local)%s > (remote)%s with sudo", source, destination) {
+		return
+	}
+
 	tempFile := "/tmp/" + uuid.NewV4().String()
 
 	t, err := template.New(path.Base(source)).ParseFiles(source)
 	if err != nil {
-		color.Red("[%s] %s %s", "local", "<", err)
+		e.reporter.OnError("local", err)
 	}
 	var tpl bytes.Buffer
 	if err := t.Execute(&tpl, context); err != nil {
-		color.Red("[%s] %s %s", "local", "<", err)
+		e.reporter.OnError("local", err)
 	}
 
 	if err := ioutil.WriteFile(tempFile, tpl.Bytes(), os.FileMode(0644)); err != nil {
-		color.Red("[%s] %s %s", "local", "<", err)
+		e.reporter.OnError("local", err)
 	} else {
-		e.Upload(tempFile, tempFile)
+		err := e.Upload(tempFile, tempFile)
 		e.Local("rm %s", tempFile)
-		e.Remote("sudo mv %s %s", tempFile, destination)
+		if err == nil {
+			e.Remote("mv %s %s", tempFile, destination, Sudo())
+		}
 	}
 }
 
 // UploadTemplateStringSudo uploads a string content to a remote file with sudo
 func (e *Exec) UploadTemplateStringSudo(content, destination string) {
+	if e.dryRunHelper("upload content > (remote)%s with sudo", destination) {
+		return
+	}
+
 	tempFile := "/tmp/" + uuid.NewV4().String()
 	if err := ioutil.WriteFile(tempFile, []byte(content), os.FileMode(0644)); err != nil {
-		color.Red("[%s] %s %s", "local", "<", err)
+		e.reporter.OnError("local", err)
 	} else {
-		e.Upload(tempFile, tempFile)
+		err := e.Upload(tempFile, tempFile)
 		e.Local("rm %s", tempFile)
-		e.Remote("sudo mv %s %s", tempFile, destination)
+		if err == nil {
+			e.Remote("mv %s %s", tempFile, destination, Sudo())
+		}
 	}
 }
 
 // LocalTemplateFile parses a local template file with context, and moves it to a destination
 func (e *Exec) LocalTemplateFile(source, destination string, context interface{}) {
+	if e.dryRunHelper("write template %s > %s", source, destination) {
+		return
+	}
+
 	tempFile := "/tmp/" + uuid.NewV4().String()
 
 	t, err := template.New(path.Base(source)).ParseFiles(source)
 	if err != nil {
-		color.Red("[%s] %s %s", "local", "<", err)
+		e.reporter.OnError("local", err)
 	}
 	var tpl bytes.Buffer
 	if err := t.Execute(&tpl, context); err != nil {
-		color.Red("[%s] %s %s", "local", "<", err)
+		e.reporter.OnError("local", err)
 	}
 
 	if err := ioutil.WriteFile(tempFile, tpl.Bytes(), os.FileMode(0644)); err != nil {
-		color.Red("[%s] %s %s", "local", "<", err)
+		e.reporter.OnError("local", err)
 	} else {
 		e.Local("mv %s %s", tempFile, destination)
 	}
@@ -165,11 +183,11 @@ func (e *Exec) LocalTemplateFile(source, destination string, context interface{}
 func (e *Exec) CompileLocalTemplateFile(source string, context interface{}) string {
 	t, err := template.New(path.Base(source)).ParseFiles(source)
 	if err != nil {
-		color.Red("[%s] %s %s", "local", "<", err)
+		e.reporter.OnError("local", err)
 	}
 	var tpl bytes.Buffer
 	if err := t.Execute(&tpl, context); err != nil {
-		color.Red("[%s] %s %s", "local", "<", err)
+		e.reporter.OnError("local", err)
 	}
 	return tpl.String()
 }
@@ -178,29 +196,33 @@ func (e *Exec) CompileLocalTemplateFile(source string, context interface{}) stri
 func (e *Exec) CompileLocalTemplateString(source string, context interface{}) string {
 	t, err := template.New(uuid.NewV4().String()).Parse(source)
 	if err != nil {
-		color.Red("[%s] %s %s", "local", "<", err)
+		e.reporter.OnError("local", err)
 	}
 	var tpl bytes.Buffer
 	if err := t.Execute(&tpl, context); err != nil {
-		color.Red("[%s] %s %s", "local", "<", err)
+		e.reporter.OnError("local", err)
 	}
 	return tpl.String()
 }
 
 // ReplaceInRemoteFile replaces a search string with a replace string, in a remote file
 func (e *Exec) ReplaceInRemoteFile(file, search, replace string) {
+	if e.dryRunHelper("replace %q with %q in (remote)%s", search, e.Parse(replace), file) {
+		return
+	}
+
 	tempFile := "/tmp/" + uuid.NewV4().String()
-	e.Remote("sudo cp %s %s ; sudo chown %s %s", file, tempFile, e.ServerContext.GetUser(), tempFile)
+	e.Remote("cp %s %s; chown %s %s", file, tempFile, e.ServerContext().GetUser(), tempFile, Sudo())
 	e.Download(tempFile, tempFile)
 	if tempFileContent, err := ioutil.ReadFile(tempFile); err != nil {
-		color.Red("[%s] %s %s", "local", "<", err)
+		e.reporter.OnError("local", err)
 	} else {
 		tempFileContent := strings.Replace(string(tempFileContent), search, e.Parse(replace), -1)
 		if err := ioutil.WriteFile(tempFile, []byte(tempFileContent), os.FileMode(0644)); err != nil {
-			color.Red("[%s] %s %s", "local", "<", err)
+			e.reporter.OnError("local", err)
 		} else {
 			e.UploadFileSudo(tempFile, file)
-			e.Remote("sudo rm -rf %s", tempFile)
+			e.Remote("rm -rf %s", tempFile, Sudo())
 			e.Local("rm -rf %s", tempFile)
 		}
 	}
@@ -208,18 +230,22 @@ func (e *Exec) ReplaceInRemoteFile(file, search, replace string) {
 
 // AddInRemoteFile appends a text string to a remote file
 func (e *Exec) AddInRemoteFile(text, file string) {
+	if e.dryRunHelper("append %q to (remote)%s", e.Parse(text), file) {
+		return
+	}
+
 	tempFile := "/tmp/" + uuid.NewV4().String()
-	e.Remote("sudo cp %s %s ; sudo chown %s %s", file, tempFile, e.ServerContext.GetUser(), tempFile)
+	e.Remote("cp %s %s; chown %s %s", file, tempFile, e.ServerContext().GetUser(), tempFile, Sudo())
 	e.Download(tempFile, tempFile)
 	if tempFileContent, err := ioutil.ReadFile(tempFile); err != nil {
-		color.Red("[%s] %s %s", "local", "<", err)
+		e.reporter.OnError("local", err)
 	} else {
 		tempFileContent := string(tempFileContent) + e.Parse(text)
 		if err := ioutil.WriteFile(tempFile, []byte(tempFileContent), os.FileMode(0644)); err != nil {
-			color.Red("[%s] %s %s", "local", "<", err)
+			e.reporter.OnError("local", err)
 		} else {
 			e.UploadFileSudo(tempFile, file)
-			e.Remote("sudo rm -rf %s", tempFile)
+			e.Remote("rm -rf %s", tempFile, Sudo())
 			e.Local("rm -rf %s", tempFile)
 		}
 	}
@@ -227,18 +253,22 @@ func (e *Exec) AddInRemoteFile(text, file string) {
 
 // RemoveFromRemoteFile cuts out a text string from remote file
 func (e *Exec) RemoveFromRemoteFile(text, file string) {
+	if e.dryRunHelper("remove %q from (remote)%s", e.Parse(text), file) {
+		return
+	}
+
 	tempFile := "/tmp/" + uuid.NewV4().String()
-	e.Remote("sudo cp %s %s ; sudo chown %s %s", file, tempFile, e.ServerContext.GetUser(), tempFile)
+	e.Remote("cp %s %s; chown %s %s", file, tempFile, e.ServerContext().GetUser(), tempFile, Sudo())
 	e.Download(tempFile, tempFile)
 	if tempFileContent, err := ioutil.ReadFile(tempFile); err != nil {
-		color.Red("[%s] %s %s", "local", "<", err)
+		e.reporter.OnError("local", err)
 	} else {
 		tempFileContent := strings.Replace(string(tempFileContent), e.Parse(text), "", -1)
 		if err := ioutil.WriteFile(tempFile, []byte(tempFileContent), os.FileMode(0644)); err != nil {
-			color.Red("[%s] %s %s", "local", "<", err)
+			e.reporter.OnError("local", err)
 		} else {
 			e.UploadFileSudo(tempFile, file)
-			e.Remote("sudo rm -rf %s", tempFile)
+			e.Remote("rm -rf %s", tempFile, Sudo())
 			e.Local("rm -rf %s", tempFile)
 		}
 	}
@@ -247,7 +277,7 @@ func (e *Exec) RemoveFromRemoteFile(text, file string) {
 // IsInRemoteFile return true if text is found in a remote file
 func (e *Exec) IsInRemoteFile(text, file string) bool {
 	text = strings.Trim(text, " ")
-	return e.Remote("if [ \"`sudo cat %s | grep '%s'`\" ]; then echo 'true'; fi", file, text).Bool()
+	return e.Remote("grep -q -e %s %s && echo 'true'", shellQuote(e.Parse(text)), shellQuote(e.Parse(file)), Sudo(), ExitCodes(1)).Bool()
 }
 
 // Ask asks a question and waits for an answer
@@ -262,7 +292,7 @@ func (e *Exec) Ask(question string, attributes ...string) string {
 		question += fmt.Sprintf(" [default: %s]", defaultResponse)
 	}
 
-	color.Green("[%s] %s %s", "local", ">", color.WhiteString(question))
+	e.reporter.OnMessage("local", question)
 	scanner.Scan()
 	response := strings.TrimSpace(scanner.Text())
 
@@ -295,7 +325,7 @@ func (e *Exec) AskWithConfirmation(question string, attributes ...bool) bool {
 		}
 	}
 
-	color.Green("[%s] %s %s", "local", ">", color.WhiteString(question))
+	e.reporter.OnMessage("local", question)
 	scanner.Scan()
 	response := strings.ToLower(strings.TrimSpace(scanner.Text()))
 
@@ -361,7 +391,7 @@ func (e *Exec) AskWithChoices(question string, attributes ...map[string]interfac
 		}
 	}
 
-	color.Green("[%s] %s %s", "local", ">", color.WhiteString(question))
+	e.reporter.OnMessage("local", question)
 
 	for loop {
 		scanner.Scan()
@@ -385,6 +415,34 @@ func (e *Exec) AskWithChoices(question string, attributes ...map[string]interfac
 	return responses
 }
 
+// dryRunHelper prints what a file helper would do in dry-run mode, returning true if so
+func (e *Exec) dryRunHelper(format string, args ...interface{}) bool {
+	if !e.dryRun {
+		return false
+	}
+
+	name := "local"
+	if s := e.current().Server(); s != nil {
+		name = s.Name
+	}
+	e.reporter.OnMessage(name, "dry-run "+fmt.Sprintf(format, args...))
+
+	return true
+}
+
+// expandHome expands a leading ~ of a local path to the user's home dir
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[1:])
+	}
+	return path
+}
+
+// shellQuote quotes s as a single shell word
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
 func commandToString(run interface{}) string {
 	var runS string
 	rt := reflect.TypeOf(run)
@@ -435,6 +493,23 @@ func mergeOptions(removeMap map[string]string, maps ...map[string]*Option) (outp
 	return output
 }
 
+// appendServers appends servers to slice, skipping the ones already in it
+func appendServers(slice []*server, servers ...*server) []*server {
+	for _, s := range servers {
+		found := false
+		for _, existing := range slice {
+			if existing == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			slice = append(slice, s)
+		}
+	}
+	return slice
+}
+
 func contains(slice []string, item string) bool {
 	set := make(map[string]struct{}, len(slice))
 	for _, s := range slice {